@@ -223,13 +223,14 @@ func parseOptions(opts ...*Option) (*Option, error) {
 		return nil, errors.New("number of options is more than 1")
 	}
 
-	opt := opts[0]
+	// copy opts[0], it may be shared by concurrent dials
+	opt := *opts[0]
 	opt.MagicNumber = DefaultOption.MagicNumber
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
 
-	return opt, nil
+	return &opt, nil
 }
 
 func Dial(network, address string, opts ...*Option) (client *Client, err error) {
@@ -319,16 +320,25 @@ func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
 	return dialTimeout(NewHTTPClient, network, address, opts...)
 }
 
+// ParseRPCAddr splits rpcAddr in the general format (protocol@addr) into protocol and addr,
+// it's how XDial parses rpcAddr, so addresses can be validated before they are dialed.
+func ParseRPCAddr(rpcAddr string) (protocol, addr string, err error) {
+	parts := strings.Split(rpcAddr, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
+	}
+	return parts[0], parts[1], nil
+}
+
 // XDial calls different functions to connect to a RPC server according the first parameter rpcAddr.
 // rpcAddr is a general format (protocol@addr) to represent a rpc server.
 // eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
-	parts := strings.Split(rpcAddr, "@")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
+	protocol, addr, err := ParseRPCAddr(rpcAddr)
+	if err != nil {
+		return nil, err
 	}
 
-	protocol, addr := parts[0], parts[1]
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
@@ -69,6 +69,15 @@ func TestClient_Call(t *testing.T) {
 		err := client.Call(context.Background(), "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
 	})
+
+	t.Run("server handle timeout defaults to connect timeout", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{
+			ConnectTimeout: time.Second,
+		})
+		var reply int
+		err := client.Call(context.Background(), "Bar.Timeout", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
+	})
 }
 
 func TestXDial(t *testing.T) {
@@ -89,3 +98,12 @@ func TestXDial(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestParseRPCAddr(t *testing.T) {
+	protocol, addr, err := ParseRPCAddr("tcp@127.0.0.1:9999")
+	_assert(err == nil && protocol == "tcp" && addr == "127.0.0.1:9999", "expect tcp and address, got %s %s %v", protocol, addr, err)
+	for _, rpcAddr := range []string{"127.0.0.1:9999", "tcp@", "@127.0.0.1:9999", "tcp@a@b"} {
+		_, _, err = ParseRPCAddr(rpcAddr)
+		_assert(err != nil, "expect error for %s", rpcAddr)
+	}
+}
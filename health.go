@@ -0,0 +1,141 @@
+package geerpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ServingStatus is the serving status of a service answered by the health method.
+type ServingStatus int
+
+const (
+	StatusUnknown ServingStatus = iota
+	StatusServing
+	StatusNotServing
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// HealthCheckMethod is the standard health method of a geerpc server.
+const HealthCheckMethod = "Health.Check"
+
+// HealthWatchMethod blocks until the serving status changes.
+const HealthWatchMethod = "Health.Watch"
+
+type HealthCheckArgs struct {
+	Service string // "" means the server as a whole
+}
+
+type HealthCheckReply struct {
+	Status ServingStatus
+}
+
+type HealthWatchArgs struct {
+	Service string
+	Status  ServingStatus // status known by the caller, Watch returns once the status differs
+	Timeout time.Duration // max time to wait, 0 or more than defaultWatchTimeout means defaultWatchTimeout
+}
+
+const defaultWatchTimeout = time.Second * 30
+
+// Health is the built-in health service registered on every Server created by NewServer.
+//
+// Every registered service is SERVING by default, "" stands for the server as a whole.
+type Health struct {
+	mu       sync.Mutex // protect following
+	statuses map[string]ServingStatus
+	changed  chan struct{} // closed and replaced on every change to wake up watchers
+	shutdown bool          // once shut down, statuses can't be changed anymore
+}
+
+func newHealth() *Health {
+	return &Health{
+		statuses: map[string]ServingStatus{"": StatusServing},
+		changed:  make(chan struct{}),
+	}
+}
+
+// SetServingStatus sets the serving status of service, "" means the server as a whole.
+// It's ignored after the server is shut down.
+func (h *Health) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shutdown {
+		return
+	}
+	h.setStatus(service, status)
+}
+
+func (h *Health) setStatus(service string, status ServingStatus) {
+	if old, ok := h.statuses[service]; ok && old == status {
+		return
+	}
+	h.statuses[service] = status
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Shutdown sets all statuses to NOT_SERVING and ignores future updates.
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shutdown = true
+	for service := range h.statuses {
+		h.setStatus(service, StatusNotServing)
+	}
+	// wake up watchers of statuses already NOT_SERVING too
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Check answers the serving status of args.Service.
+func (h *Health) Check(args HealthCheckArgs, reply *HealthCheckReply) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status, ok := h.statuses[args.Service]
+	if !ok {
+		return errors.New("rpc health: unknown service " + args.Service)
+	}
+	reply.Status = status
+	return nil
+}
+
+// Watch blocks until the serving status of args.Service differs from args.Status,
+// args.Timeout elapses or the server is shut down, then answers the current status.
+func (h *Health) Watch(args HealthWatchArgs, reply *HealthCheckReply) error {
+	timeout := args.Timeout
+	if timeout <= 0 || timeout > defaultWatchTimeout {
+		timeout = defaultWatchTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		h.mu.Lock()
+		status, changed, shutdown := h.statuses[args.Service], h.changed, h.shutdown
+		h.mu.Unlock()
+
+		reply.Status = status
+		if status != args.Status || shutdown {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	t.Run("check", func(t *testing.T) {
+		var reply HealthCheckReply
+		err := client.Call(context.Background(), HealthCheckMethod, HealthCheckArgs{Service: "Foo"}, &reply)
+		_assert(err == nil && reply.Status == StatusServing, "expect Foo SERVING, got %s %v", reply.Status, err)
+
+		err = client.Call(context.Background(), HealthCheckMethod, HealthCheckArgs{Service: "Bar"}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "unknown service"), "expect unknown service error")
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		call := client.Go(HealthWatchMethod, HealthWatchArgs{Service: "Foo", Status: StatusServing}, &HealthCheckReply{}, nil)
+		time.Sleep(time.Millisecond * 100)
+		server.Health().SetServingStatus("Foo", StatusNotServing)
+		call = <-call.Done
+		_assert(call.Error == nil && call.Reply.(*HealthCheckReply).Status == StatusNotServing, "expect watch to notify NOT_SERVING")
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		server.Health().SetServingStatus("Foo", StatusServing)
+		call := client.Go(HealthWatchMethod, HealthWatchArgs{Status: StatusServing}, &HealthCheckReply{}, nil)
+		time.Sleep(time.Millisecond * 100)
+
+		err := server.Shutdown(context.Background())
+		_assert(err == nil, "expect shutdown without error, got %v", err)
+		call = <-call.Done
+		_assert(call.Error == nil && call.Reply.(*HealthCheckReply).Status == StatusNotServing,
+			"expect NOT_SERVING before draining, got %v", call.Error)
+
+		_, err = Dial("tcp", l.Addr().String())
+		_assert(err != nil, "expect listener closed after shutdown")
+	})
+}
+
+func TestHealth_WatchShutdown(t *testing.T) {
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	server.Health().SetServingStatus("Foo", StatusNotServing)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// the status doesn't change on shutdown, watch must return anyway
+	args := HealthWatchArgs{Service: "Foo", Status: StatusNotServing, Timeout: time.Hour}
+	call := client.Go(HealthWatchMethod, args, &HealthCheckReply{}, nil)
+	time.Sleep(time.Millisecond * 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	_assert(err == nil, "expect watch to return on shutdown, got %v", err)
+	call = <-call.Done
+	_assert(call.Error == nil && call.Reply.(*HealthCheckReply).Status == StatusNotServing, "expect NOT_SERVING, got %v", call.Error)
+}
@@ -40,7 +40,7 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	l, _ := net.Listen("tcp", ":0")
 	server := geerpc.NewServer()
 	_ = server.Register(&foo)
-	registry.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	_, _ = registry.RegisterServer(server, l, registryAddr, registry.ServerItem{}, nil)
 	wg.Done()
 	server.Accept(l)
 }
@@ -0,0 +1,125 @@
+package geerpc
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// TypeSchema describes a Go type in a machine-readable way, so generic tools
+// can build arguments and read replies without compiled-in types.
+type TypeSchema struct {
+	Name   string        // type name, e.g. "int", "main.Args", "[]string"
+	Kind   string        // reflect.Kind name, e.g. "int", "struct", "slice", "map", "ptr"
+	Elem   *TypeSchema   // element type of ptr, slice, array and map
+	Key    *TypeSchema   // key type of map
+	Len    int           // length of array
+	Fields []FieldSchema // exported fields of struct
+	Ref    bool          // the type is recursive and already described by an outer schema of the same Name
+}
+
+type FieldSchema struct {
+	Name     string
+	Type     *TypeSchema
+	Tag      string
+	Embedded bool
+}
+
+type MethodSchema struct {
+	Name  string
+	Args  *TypeSchema
+	Reply *TypeSchema
+}
+
+type ServiceSchema struct {
+	Name    string
+	Methods []MethodSchema // sorted by name
+}
+
+type ListServicesArgs struct {
+	Prefix string // lists services whose names start with Prefix, "" lists all
+}
+
+type DescribeServiceArgs struct {
+	Service string
+}
+
+// Reflection is the built-in reflection service registered on every Server created by NewServer,
+// it describes the services registered on the server.
+type Reflection struct {
+	server *Server
+}
+
+// ListServices answers the sorted names of registered services.
+func (r *Reflection) ListServices(args ListServicesArgs, reply *[]string) error {
+	var names []string
+	r.server.serviceMap.Range(func(nameI, _ interface{}) bool {
+		if name := nameI.(string); strings.HasPrefix(name, args.Prefix) {
+			names = append(names, name)
+		}
+		return true
+	})
+	sort.Strings(names)
+	*reply = names
+	return nil
+}
+
+// DescribeService answers the methods of a service and the schema of their argument and reply types.
+func (r *Reflection) DescribeService(args DescribeServiceArgs, reply *ServiceSchema) error {
+	svcI, ok := r.server.serviceMap.Load(args.Service)
+	if !ok {
+		return errors.New("rpc reflection: can't find service: " + args.Service)
+	}
+	*reply = describeService(svcI.(*service))
+	return nil
+}
+
+func describeService(svc *service) ServiceSchema {
+	schema := ServiceSchema{Name: svc.name}
+	for name, mType := range svc.method {
+		schema.Methods = append(schema.Methods, MethodSchema{
+			Name:  name,
+			Args:  describeType(mType.ArgType, make(map[reflect.Type]bool)),
+			Reply: describeType(mType.ReplyType, make(map[reflect.Type]bool)),
+		})
+	}
+	sort.Slice(schema.Methods, func(i, j int) bool { return schema.Methods[i].Name < schema.Methods[j].Name })
+	return schema
+}
+
+// describeType builds the schema of t, stack holds the types being described to stop at recursive types.
+func describeType(t reflect.Type, stack map[reflect.Type]bool) *TypeSchema {
+	schema := &TypeSchema{Name: t.String(), Kind: t.Kind().String()}
+	if stack[t] {
+		schema.Ref = true
+		return schema
+	}
+	stack[t] = true
+	defer delete(stack, t)
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		schema.Elem = describeType(t.Elem(), stack)
+	case reflect.Array:
+		schema.Len = t.Len()
+		schema.Elem = describeType(t.Elem(), stack)
+	case reflect.Map:
+		schema.Key = describeType(t.Key(), stack)
+		schema.Elem = describeType(t.Elem(), stack)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue // codecs only encode exported fields
+			}
+			schema.Fields = append(schema.Fields, FieldSchema{
+				Name:     f.Name,
+				Type:     describeType(f.Type, stack),
+				Tag:      string(f.Tag),
+				Embedded: f.Anonymous,
+			})
+		}
+	}
+	return schema
+}
@@ -0,0 +1,52 @@
+package geerpc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+type Node struct {
+	Name     string
+	Children []*Node
+	Attrs    map[string]int
+	secret   int
+}
+
+type Tree int
+
+func (t Tree) Walk(root Node, reply *[]string) error {
+	return nil
+}
+
+func TestReflection(t *testing.T) {
+	var tree Tree
+	server := NewServer()
+	_ = server.Register(tree)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	t.Run("list services", func(t *testing.T) {
+		var names []string
+		err := client.Call(context.Background(), "Reflection.ListServices", ListServicesArgs{}, &names)
+		_assert(err == nil && len(names) == 3 && names[2] == "Tree", "expect 3 services, got %v %v", names, err)
+	})
+
+	t.Run("describe service", func(t *testing.T) {
+		var schema ServiceSchema
+		err := client.Call(context.Background(), "Reflection.DescribeService", DescribeServiceArgs{Service: "Tree"}, &schema)
+		_assert(err == nil && len(schema.Methods) == 1, "expect 1 method, got %v", err)
+
+		m := schema.Methods[0]
+		_assert(m.Name == "Walk" && m.Reply.Kind == "ptr" && m.Reply.Elem.Kind == "slice", "unexpected method schema %+v", m)
+		args := m.Args
+		_assert(args.Kind == "struct" && len(args.Fields) == 3, "expect 3 exported fields, got %d", len(args.Fields))
+		children := args.Fields[1].Type
+		_assert(children.Elem.Elem.Ref && children.Elem.Elem.Name == "geerpc.Node", "expect recursive type marked Ref")
+		attrs := args.Fields[2].Type
+		_assert(attrs.Kind == "map" && attrs.Key.Kind == "string" && attrs.Elem.Kind == "int", "unexpected map schema")
+	})
+}
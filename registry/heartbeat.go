@@ -0,0 +1,319 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geerpc"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HeartbeatOption configures a Heartbeater.
+type HeartbeatOption struct {
+	// Interval is the time between two heartbeats, 0 means a third of the TTL of the server,
+	// or a minute less than the default timeout of GeeRegistry if the server has no TTL.
+	Interval time.Duration
+	Timeout  time.Duration // timeout of a single heartbeat or deregistration
+	Client   *http.Client  // client sending heartbeats, nil means http.DefaultClient
+	// MinBackoff is the time before retrying a failed heartbeat, it doubles on every
+	// consecutive failure up to MaxBackoff, and never exceeds Interval.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnStatus is called after every heartbeat, it must not block.
+	OnStatus func(status HeartbeatStatus)
+	// Services reports the services of the server before every heartbeat, e.g. geerpc.Server.Services,
+	// so services registered later are reported too. nil means the Services of the item.
+	Services func() []string
+	// Option is used to dial a registry given as a geerpc address (protocol@addr),
+	// nil means geerpc.DefaultOption.
+	Option *geerpc.Option
+}
+
+var DefaultHeartbeatOption = &HeartbeatOption{
+	Timeout:    time.Second * 10,
+	MinBackoff: time.Second,
+	MaxBackoff: time.Second * 30,
+}
+
+// HeartbeatStatus is the result of the last heartbeat of a Heartbeater.
+type HeartbeatStatus struct {
+	Addr     string
+	Err      error // nil if the last heartbeat succeeded
+	Failures int   // consecutive failures
+	Time     time.Time
+}
+
+// Heartbeater registers a server to a registry and keeps it alive by heartbeats,
+// failed heartbeats are retried with backoff until the Heartbeater is stopped.
+//
+// The registry is either the URL of a GeeRegistry, or the geerpc address (protocol@addr)
+// of its Registry service.
+type Heartbeater struct {
+	registry string
+	item     ServerItem
+	opt      HeartbeatOption
+	rpc      *Client    // client of the Registry service, nil if registry is a URL
+	mu       sync.Mutex // protect following
+	status   HeartbeatStatus
+	started  bool
+	stopping bool
+	once     sync.Once // stop only once
+	done     chan struct{}
+	stopped  chan struct{} // closed when the loop exits
+}
+
+// NewHeartbeater creates a Heartbeater sending item to registry, nil opt means DefaultHeartbeatOption.
+// Zero fields of opt take the value of DefaultHeartbeatOption.
+func NewHeartbeater(registry string, item ServerItem, opt *HeartbeatOption) *Heartbeater {
+	h := &Heartbeater{
+		registry: registry,
+		item:     item,
+		opt:      *DefaultHeartbeatOption,
+		status:   HeartbeatStatus{Addr: item.Addr},
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if opt != nil {
+		h.opt.Interval = opt.Interval
+		h.opt.Client = opt.Client
+		h.opt.OnStatus = opt.OnStatus
+		h.opt.Services = opt.Services
+		h.opt.Option = opt.Option
+		if opt.Timeout > 0 {
+			h.opt.Timeout = opt.Timeout
+		}
+		if opt.MinBackoff > 0 {
+			h.opt.MinBackoff = opt.MinBackoff
+		}
+		if opt.MaxBackoff > 0 {
+			h.opt.MaxBackoff = opt.MaxBackoff
+		}
+	}
+	if h.opt.Interval <= 0 && item.TTL > 0 {
+		// leave time for two more heartbeats before it moved from registry.
+		h.opt.Interval = item.TTL / 3
+	}
+	if h.opt.Interval <= 0 {
+		// make sure there is enough time to send heartbeat before it moved from registry.
+		h.opt.Interval = defaultTimeout - time.Duration(1)*time.Minute
+	}
+	if h.opt.MaxBackoff > h.opt.Interval {
+		h.opt.MaxBackoff = h.opt.Interval
+	}
+	if h.opt.Client == nil {
+		h.opt.Client = http.DefaultClient
+	}
+	if IsRPCAddr(registry) {
+		h.rpc = NewClient(registry, h.opt.Option)
+	}
+	return h
+}
+
+// Start sends the first heartbeat and keeps sending heartbeats in background.
+// It returns the error of the first heartbeat, which is retried anyway.
+// A Heartbeater can be started only once.
+func (h *Heartbeater) Start() error {
+	h.mu.Lock()
+	if h.started || h.stopping {
+		h.mu.Unlock()
+		return errors.New("rpc registry: heartbeater already started or stopped")
+	}
+	h.started = true
+	h.mu.Unlock()
+
+	err := h.beat()
+	go h.run(err)
+	return err
+}
+
+// Stop stops sending heartbeats and deregisters the server from the registry.
+func (h *Heartbeater) Stop() error {
+	var err error
+	h.once.Do(func() {
+		h.mu.Lock()
+		h.stopping = true
+		started := h.started
+		h.mu.Unlock()
+
+		close(h.done)
+		if started {
+			<-h.stopped
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), h.opt.Timeout)
+		defer cancel()
+		if h.rpc != nil {
+			err = h.rpc.Deregister(ctx, h.item.Addr)
+			_ = h.rpc.Close()
+			return
+		}
+		err = deregister(ctx, h.opt.Client, h.registry, h.item.Addr)
+	})
+	return err
+}
+
+// Status returns the result of the last heartbeat.
+func (h *Heartbeater) Status() HeartbeatStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+func (h *Heartbeater) run(err error) {
+	defer close(h.stopped)
+	backoff := h.opt.MinBackoff
+	for {
+		wait := h.opt.Interval
+		if err != nil {
+			wait = backoff
+			if backoff *= 2; backoff > h.opt.MaxBackoff {
+				backoff = h.opt.MaxBackoff
+			}
+		} else {
+			backoff = h.opt.MinBackoff
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-h.done:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		err = h.beat()
+	}
+}
+
+// beat sends a heartbeat and reports its status.
+func (h *Heartbeater) beat() error {
+	if h.opt.Services != nil {
+		h.item.Services = h.opt.Services()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.opt.Timeout)
+	defer cancel()
+	var err error
+	if h.rpc != nil {
+		log.Println(h.item.Addr, "send heartbeat to registry", h.registry)
+		if err = h.rpc.Register(ctx, h.item); err != nil {
+			log.Println("rpc server: heartbeat err:", err)
+		}
+	} else {
+		err = sendHeartBeat(ctx, h.opt.Client, h.registry, h.item)
+	}
+
+	h.mu.Lock()
+	h.status.Err = err
+	h.status.Time = time.Now()
+	if err != nil {
+		h.status.Failures++
+	} else {
+		h.status.Failures = 0
+	}
+	status := h.status
+	h.mu.Unlock()
+
+	if h.opt.OnStatus != nil {
+		h.opt.OnStatus(status)
+	}
+	return err
+}
+
+// Heartbeat send a heartbeat message every once in a while
+// it's a helper function for a server to registry or send heartbeat
+func Heartbeat(registry, addr string, duration time.Duration) {
+	HeartbeatServer(registry, ServerItem{Addr: addr}, duration)
+}
+
+// HeartbeatServer is like Heartbeat, but registers item with all its metadata,
+// e.g. the weight used by weighted select modes and the labels used by label-aware routing.
+// If item.TTL is set, duration defaults to a third of it.
+//
+// Heartbeats never stop, use a Heartbeater to stop them.
+func HeartbeatServer(registry string, item ServerItem, duration time.Duration) {
+	_ = NewHeartbeater(registry, item, &HeartbeatOption{Interval: duration}).Start()
+}
+
+// sendHeartBeat posts item in JSON, and also in headers so old registries can read it.
+func sendHeartBeat(ctx context.Context, client *http.Client, registry string, item ServerItem) error {
+	log.Println(item.Addr, "send heartbeat to registry", registry)
+	body, err := json.Marshal(&item)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", registry, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Geerpc-Servers", item.Addr)
+	if item.Namespace != "" {
+		req.Header.Set("X-Geerpc-Namespace", item.Namespace)
+	}
+	if item.Weight > 0 {
+		req.Header.Set("X-Geerpc-Weight", strconv.Itoa(item.Weight))
+	}
+	if labels := item.AllLabels(); len(labels) > 0 {
+		req.Header.Set("X-Geerpc-Labels", encodeLabels(labels))
+	}
+	if item.TTL > 0 {
+		req.Header.Set("X-Geerpc-TTL", item.TTL.String())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("rpc server: heartbeat err:", err)
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("rpc registry: heartbeat status %s", resp.Status)
+		log.Println("rpc server: heartbeat err:", err)
+		return err
+	}
+	return nil
+}
+
+// Deregister removes addr from registry at once, so it stops getting traffic
+// before it expires. Call it when the server is shutting down.
+// registry is a URL or the geerpc address of a Registry service.
+// It gives up after the timeout of DefaultHeartbeatOption.
+func Deregister(registry, addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultHeartbeatOption.Timeout)
+	defer cancel()
+	if IsRPCAddr(registry) {
+		c := NewClient(registry, nil)
+		defer func() { _ = c.Close() }()
+		return c.Deregister(ctx, addr)
+	}
+	return deregister(ctx, http.DefaultClient, registry, addr)
+}
+
+func deregister(ctx context.Context, client *http.Client, registry, addr string) error {
+	u, err := url.Parse(registry)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("addr", addr)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	// not found means it's already expired or deregistered
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("rpc registry: deregister %s status %s", addr, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHeartbeater(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	var failures int32 = 3
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" && atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable) // registry blips
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+
+	statuses := make(chan HeartbeatStatus, 10)
+	h := NewHeartbeater(ts.URL, ServerItem{Addr: "tcp@a"}, &HeartbeatOption{
+		Interval:   time.Minute,
+		MinBackoff: time.Millisecond * 10,
+		OnStatus:   func(status HeartbeatStatus) { statuses <- status },
+	})
+	err := h.Start()
+	_assert(err != nil, "expect the first heartbeat failed")
+
+	for i := 1; i <= 3; i++ {
+		status := <-statuses
+		_assert(status.Err != nil && status.Failures == i, "expect failure %d, got %+v", i, status)
+	}
+	status := <-statuses
+	_assert(status.Err == nil && status.Failures == 0, "expect heartbeat retried successfully, got %+v", status)
+	_assert(h.Status().Err == nil, "expect status ok")
+	list := getServers(ts.URL)
+	_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@a", "expect tcp@a registered, got %+v", list)
+
+	_assert(h.Stop() == nil, "stop error")
+	list = getServers(ts.URL)
+	_assert(len(list.Servers) == 0, "expect tcp@a deregistered after stop, got %+v", list)
+	_assert(h.Start() != nil, "expect a stopped heartbeater not started again")
+
+	// stopping a heartbeater never started doesn't wait for its loop
+	h = NewHeartbeater(ts.URL, ServerItem{Addr: "tcp@b"}, nil)
+	_assert(h.Stop() == nil, "stop error")
+}
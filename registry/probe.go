@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"geerpc"
+	"log"
+	"sync"
+	"time"
+)
+
+// ProbeOption configures the active health probing of registered servers.
+//
+// Heartbeats only tell that the process of a server is alive, probing dials every server
+// and calls its health method, so a server whose listener is wedged is found out too.
+// Failing servers are still listed, but marked Unhealthy.
+type ProbeOption struct {
+	Interval  time.Duration  // time between two probes of a server
+	Timeout   time.Duration  // timeout of dialing and calling the health method
+	Threshold int            // consecutive failed probes before a server is marked unhealthy
+	Option    *geerpc.Option // used to dial servers, nil means geerpc.DefaultOption
+}
+
+var DefaultProbeOption = &ProbeOption{
+	Interval:  time.Second * 10,
+	Timeout:   time.Second * 3,
+	Threshold: 2,
+}
+
+type prober struct {
+	r        *GeeRegistry
+	opt      *ProbeOption
+	failures map[string]int // consecutive failed probes of servers, used by run only
+}
+
+// EnableProbe starts probing registered servers by geerpc.HealthCheckMethod, nil opt means
+// DefaultProbeOption and zero fields of opt are taken from it, opt isn't modified.
+// It should be called once before r is used, probing stops when r is closed.
+//
+// Only the registry probing a server marks it unhealthy, the mark isn't replicated nor stored.
+func (r *GeeRegistry) EnableProbe(opt *ProbeOption) {
+	if opt == nil {
+		opt = DefaultProbeOption
+	}
+	o := *opt
+	if o.Interval <= 0 {
+		o.Interval = DefaultProbeOption.Interval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultProbeOption.Timeout
+	}
+	if o.Threshold <= 0 {
+		o.Threshold = DefaultProbeOption.Threshold
+	}
+	p := &prober{r: r, opt: &o, failures: make(map[string]int)}
+	go p.run()
+}
+
+func (p *prober) run() {
+	t := time.NewTicker(p.opt.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.r.done:
+			return
+		case <-t.C:
+		}
+		p.probeAll()
+	}
+}
+
+// probeAll probes all registered servers at once and marks the failing ones.
+func (p *prober) probeAll() {
+	servers, _ := p.r.aliveServers(&lookup{})
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.probe(servers[i].Addr)
+		}(i)
+	}
+	wg.Wait()
+
+	failures := make(map[string]int, len(servers)) // servers gone are forgotten
+	for i, s := range servers {
+		if errs[i] != nil {
+			failures[s.Addr] = p.failures[s.Addr] + 1
+			log.Println("rpc registry: probe", s.Addr, "error:", errs[i])
+		}
+	}
+	p.failures = failures
+
+	r := p.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unhealthy := make(map[string]bool)
+	changed := false
+	for _, s := range servers {
+		marked := failures[s.Addr] >= p.opt.Threshold
+		if marked {
+			unhealthy[s.Addr] = true
+		}
+		if marked != r.unhealthy[s.Addr] {
+			changed = true
+		}
+	}
+	r.unhealthy = unhealthy
+	if changed {
+		r.change()
+	}
+}
+
+// probe dials addr and checks the server as a whole is serving.
+func (p *prober) probe(addr string) error {
+	opt := *geerpc.DefaultOption
+	if p.opt.Option != nil {
+		opt = *p.opt.Option
+	}
+	opt.ConnectTimeout = p.opt.Timeout
+	client, err := geerpc.XDial(addr, &opt)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.opt.Timeout)
+	defer cancel()
+	var reply geerpc.HealthCheckReply
+	if err := client.Call(ctx, geerpc.HealthCheckMethod, geerpc.HealthCheckArgs{}, &reply); err != nil {
+		return err
+	}
+	if reply.Status != geerpc.StatusServing {
+		return fmt.Errorf("rpc registry: %s is %s", addr, reply.Status)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"geerpc"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitHealth waits until the lookup result of addr is marked unhealthy or not.
+func waitHealth(url, addr string, unhealthy bool) bool {
+	deadline := time.Now().Add(time.Second * 2)
+	for time.Now().Before(deadline) {
+		for _, s := range getServers(url).Servers {
+			if s.Addr == addr && s.Unhealthy == unhealthy {
+				return true
+			}
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return false
+}
+
+func TestProbe(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	r.EnableProbe(&ProbeOption{Interval: time.Millisecond * 50, Timeout: time.Millisecond * 200, Threshold: 2})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	server := geerpc.NewServer()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	live := "tcp@" + l.Addr().String()
+	wedged, _ := net.Listen("tcp", "127.0.0.1:0") // accepts connections, but never answers
+	defer func() { _ = wedged.Close() }()
+	dead := "tcp@" + wedged.Addr().String()
+	register(ts.URL, ServerItem{Addr: live})
+	register(ts.URL, ServerItem{Addr: dead})
+
+	_assert(waitHealth(ts.URL, dead, true), "expect wedged server marked unhealthy")
+	_assert(waitHealth(ts.URL, live, false), "expect serving server healthy")
+
+	server.Health().SetServingStatus("", geerpc.StatusNotServing)
+	_assert(waitHealth(ts.URL, live, true), "expect not serving server marked unhealthy")
+	server.Health().SetServingStatus("", geerpc.StatusServing)
+	_assert(waitHealth(ts.URL, live, false), "expect server healthy again after a successful probe")
+
+	resp, err := ts.Client().Get(ts.URL)
+	_assert(err == nil, "get error: %v", err)
+	_ = resp.Body.Close()
+	servers := resp.Header.Get("X-Geerpc-Servers")
+	_assert(servers == live, "expect unhealthy server left out of headers, got %s", servers)
+}
+
+func TestEnableProbe_Option(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	r.EnableProbe(nil)
+
+	r2 := New(time.Minute)
+	defer func() { _ = r2.Close() }()
+	opt := &ProbeOption{Interval: time.Hour}
+	r2.EnableProbe(opt)
+	_assert(opt.Timeout == 0 && opt.Threshold == 0, "expect opt of the caller unchanged, got %+v", opt)
+	_assert(DefaultProbeOption.Interval == time.Second*10, "expect DefaultProbeOption unchanged")
+}
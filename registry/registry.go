@@ -1,9 +1,16 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,117 +21,583 @@ import (
 // add a server and receive heartbeat to keep it alive.
 //
 // return all alive servers and delete dead servers sync simultaneously.
+//
+// every change of alive servers increases the revision, so discoveries can watch changes.
+//
+// a janitor goroutine deletes dead servers once they expire, it starts with the first
+// registration and stops when the registry is closed.
+//
+// registrations are saved to an optional Store and reloaded on startup, reloaded servers
+// are provisional until they heartbeat again.
+//
+// besides HTTP, the registry is served over geerpc by its Registry service, see Service.
+//
+// optionally, the registry probes servers by their health method and marks the failing
+// ones unhealthy, see EnableProbe.
 type GeeRegistry struct {
-	timeout time.Duration
-	mu      sync.Mutex
-	servers map[string]*ServerItem
+	timeout  time.Duration // 0 means servers never expire unless they have a TTL
+	mu       sync.Mutex    // protect following
+	servers  map[string]*ServerItem
+	revision uint64
+	changed  chan struct{} // closed and replaced on every change to wake up watchers
+	store    Store         // nil means registrations aren't persisted
+	// tombstones holds when servers were deleted, so late replicated heartbeats
+	// don't bring them back.
+	tombstones map[string]time.Time
+	replicator *replicator     // nil means replication is disabled
+	unhealthy  map[string]bool // servers failing active probes, see EnableProbe
+	janitor    sync.Once       // start the janitor only once
+	close      sync.Once       // close done only once
+	done       chan struct{}
 }
 
+// ServerItem is a server registered in the registry with its metadata.
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr      string            `json:"addr"`
+	Namespace string            `json:"namespace,omitempty"` // environment of the server, DefaultNamespace if empty
+	Services  []string          `json:"services,omitempty"`  // names of services served, empty if unknown
+	Weight    int               `json:"weight,omitempty"`    // 0 means not set, discovery uses its default weight
+	Version   string            `json:"version,omitempty"`
+	Zone      string            `json:"zone,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"` // e.g. canary, used by label-aware routing
+	// TTL is how long the server is alive after a heartbeat, in nanoseconds in JSON.
+	// 0 means the timeout of the registry.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Provisional is set on servers reloaded from the Store of a restarted registry
+	// until they heartbeat again.
+	Provisional bool `json:"provisional,omitempty"`
+	// Unhealthy is set in lookup results on servers failing the active probes of the registry,
+	// see EnableProbe. Discoveries skip them.
+	Unhealthy bool `json:"unhealthy,omitempty"`
+	start     time.Time
+}
+
+// DefaultNamespace is the namespace of servers registered without one.
+const DefaultNamespace = "default"
+
+// ServerList is the JSON answered by GET.
+type ServerList struct {
+	Revision uint64       `json:"revision"`
+	Servers  []ServerItem `json:"servers"`
+}
+
+// AllLabels returns Labels with Zone and Version as the "zone" and "version" labels.
+func (s *ServerItem) AllLabels() map[string]string {
+	if s.Zone == "" && s.Version == "" {
+		return s.Labels
+	}
+	labels := make(map[string]string, len(s.Labels)+2)
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+	if s.Zone != "" {
+		labels["zone"] = s.Zone
+	}
+	if s.Version != "" {
+		labels["version"] = s.Version
+	}
+	return labels
+}
+
+// lookup scopes the servers answered by GET.
+type lookup struct {
+	namespace string   // "" means all namespaces
+	service   string   // "" means all servers, otherwise only servers reporting service
+	tags      []string // only servers with all tags
+}
+
+func (l *lookup) match(s *ServerItem) bool {
+	return (l.namespace == "" || s.Namespace == l.namespace) &&
+		(l.service == "" || s.hasService(l.service)) && s.hasTags(l.tags)
+}
+
+func (s *ServerItem) hasService(service string) bool {
+	for _, name := range s.Services {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ServerItem) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range s.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks a registration before it's accepted.
+func (s *ServerItem) validate() error {
+	switch {
+	case s.Addr == "":
+		return errors.New("rpc registry: missing server address")
+	case s.Weight < 0:
+		return errors.New("rpc registry: negative weight of " + s.Addr)
+	case s.TTL < 0:
+		return errors.New("rpc registry: negative ttl of " + s.Addr)
+	}
+	return nil
+}
+
+// sameMeta reports whether s has the same metadata as item.
+func (s *ServerItem) sameMeta(item *ServerItem) bool {
+	a, b := *s, *item
+	a.start, b.start = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
 }
 
 const (
-	defaultPath    = "/_geerpc_/registry"
-	defaultTimeout = time.Minute * 5
+	defaultPath         = "/_geerpc_/registry"
+	defaultTimeout      = time.Minute * 5
+	defaultWatchTimeout = time.Second * 30
+	// tombstoneTimeout is how long deletions are remembered, longer than
+	// replicated records are expected to be delayed.
+	tombstoneTimeout = defaultTimeout
 )
 
 func New(timeout time.Duration) *GeeRegistry {
 	return &GeeRegistry{
-		servers: make(map[string]*ServerItem),
-		timeout: timeout,
+		servers:    make(map[string]*ServerItem),
+		tombstones: make(map[string]time.Time),
+		unhealthy:  make(map[string]bool),
+		timeout:    timeout,
+		// start from the current time, so a restarted registry doesn't repeat
+		// the revisions known by watchers.
+		revision: uint64(time.Now().UnixNano()),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
 	}
 }
 
+// NewWithStore creates a GeeRegistry saving registrations to store, servers saved before
+// are reloaded as provisional and expire like others unless they heartbeat again.
+func NewWithStore(timeout time.Duration, store Store) (*GeeRegistry, error) {
+	items, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	r := New(timeout)
+	r.store = store
+	now := time.Now()
+	for i := range items {
+		item := items[i]
+		item.Provisional = true
+		item.start = now
+		r.servers[item.Addr] = &item
+	}
+	if len(items) > 0 {
+		r.janitor.Do(func() { go r.runJanitor() })
+	}
+	return r, nil
+}
+
+// Close stops the janitor and replication and closes the store, dead servers
+// are still deleted when servers are looked up.
+func (r *GeeRegistry) Close() error {
+	var err error
+	r.close.Do(func() {
+		close(r.done)
+		if r.store != nil {
+			r.mu.Lock()
+			err = r.store.Close()
+			r.mu.Unlock()
+		}
+	})
+	return err
+}
+
 var DefaultGeeRegister = New(defaultTimeout)
 
-func (r *GeeRegistry) putServer(addr string) {
+func (r *GeeRegistry) putServer(item ServerItem) {
+	now := time.Now()
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.put(item, now)
+	r.mu.Unlock()
+	r.replicate(replicaRecord{Item: &item, Time: now})
+}
 
-	s := r.servers[addr]
-	if s == nil {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
-	} else {
-		s.start = time.Now() // if exits, update start time to keep alive.
+// put registers item which heartbeat at beat, r.mu must be held.
+// It's ignored if the server was deleted or heartbeat again after beat.
+func (r *GeeRegistry) put(item ServerItem, beat time.Time) {
+	r.janitor.Do(func() { go r.runJanitor() })
+
+	if deleted, ok := r.tombstones[item.Addr]; ok {
+		if !beat.After(deleted) {
+			return
+		}
+		delete(r.tombstones, item.Addr)
+	}
+	item.start = beat
+	item.Provisional = false
+	item.Unhealthy = false // only set by probes of this registry
+	if item.Namespace == "" {
+		item.Namespace = DefaultNamespace
+	}
+	s := r.servers[item.Addr]
+	switch {
+	case s == nil:
+		r.servers[item.Addr] = &item
+		r.change()
+		r.save(&item)
+	case beat.Before(s.start):
+		// a late replicated heartbeat, the server heartbeat again since.
+	case !s.sameMeta(&item):
+		*s = item // metadata may be changed at runtime
+		r.change()
+		r.save(&item)
+	default:
+		s.start = item.start // if exits, update start time to keep alive.
 	}
 }
 
-func (r *GeeRegistry) aliveServers() []string {
+// deleteServer deletes addr and reports whether it was registered.
+func (r *GeeRegistry) deleteServer(addr string) bool {
+	now := time.Now()
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	ok := r.remove(addr, now)
+	r.mu.Unlock()
+	r.replicate(replicaRecord{Addr: addr, Time: now, Deleted: true})
+	return ok
+}
+
+// remove deletes addr which was deregistered at t and reports whether it was registered,
+// r.mu must be held. It's ignored if the server heartbeat after t.
+func (r *GeeRegistry) remove(addr string, t time.Time) bool {
+	if deleted, ok := r.tombstones[addr]; !ok || t.After(deleted) {
+		r.tombstones[addr] = t
+	}
+	s, ok := r.servers[addr]
+	if !ok || s.start.After(t) {
+		return false
+	}
+	delete(r.servers, addr)
+	r.change()
+	r.unsave(addr)
+	return true
+}
+
+// save writes item to the store, r.mu must be held.
+func (r *GeeRegistry) save(item *ServerItem) {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.Put(*item); err != nil {
+		log.Println("rpc registry: save", item.Addr, "error:", err)
+	}
+}
+
+// unsave deletes addr from the store, r.mu must be held.
+func (r *GeeRegistry) unsave(addr string) {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.Delete(addr); err != nil {
+		log.Println("rpc registry: delete", addr, "error:", err)
+	}
+}
 
-	var alive []string
+// change increases the revision and wakes up watchers, r.mu must be held.
+func (r *GeeRegistry) change() {
+	r.revision++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// expire deletes dead servers and old tombstones, and returns when the next server expires,
+// zero if no server expires. r.mu must be held.
+func (r *GeeRegistry) expire() time.Time {
+	var next time.Time
+	now := time.Now()
 	for addr, s := range r.servers {
-		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
-		} else {
+		ttl := s.TTL
+		if ttl <= 0 {
+			ttl = r.timeout
+		}
+		if ttl <= 0 {
+			continue // never expires
+		}
+		deadline := s.start.Add(ttl)
+		if !deadline.After(now) {
 			delete(r.servers, addr)
+			r.change()
+			r.unsave(addr)
+		} else if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	for addr, deleted := range r.tombstones {
+		if now.Sub(deleted) > tombstoneTimeout {
+			delete(r.tombstones, addr)
+		}
+	}
+	return next
+}
+
+// runJanitor deletes dead servers as soon as they expire, until r is closed.
+func (r *GeeRegistry) runJanitor() {
+	for {
+		r.mu.Lock()
+		next := r.expire()
+		changed := r.changed
+		r.mu.Unlock()
+
+		if !r.sleep(changed, next) {
+			return
+		}
+	}
+}
+
+// sleep blocks until changed is closed, servers may expire sooner then,
+// or until next if it isn't zero. It returns false if r is closed.
+func (r *GeeRegistry) sleep(changed <-chan struct{}, next time.Time) bool {
+	var expired <-chan time.Time
+	if !next.IsZero() {
+		t := time.NewTimer(time.Until(next))
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-r.done:
+		return false
+	case <-changed:
+	case <-expired:
+	}
+	return true
+}
+
+// aliveServers returns a copy of alive servers matching l sorted by address and the current revision.
+func (r *GeeRegistry) aliveServers(l *lookup) ([]ServerItem, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	alive := make([]ServerItem, 0, len(r.servers))
+	for _, s := range r.servers {
+		if l.match(s) {
+			item := *s
+			item.Unhealthy = r.unhealthy[s.Addr]
+			alive = append(alive, item)
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Addr < alive[j].Addr })
+	return alive, r.revision
+}
+
+// wait blocks until the revision differs from revision, timeout elapses, ctx is done or r is closed.
+// timeout is capped at defaultWatchTimeout, 0 means defaultWatchTimeout.
+func (r *GeeRegistry) wait(ctx context.Context, revision uint64, timeout time.Duration) {
+	if timeout <= 0 || timeout > defaultWatchTimeout {
+		timeout = defaultWatchTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		r.mu.Lock()
+		current, changed := r.revision, r.changed
+		r.mu.Unlock()
+
+		if current != revision {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		case <-r.done:
+			return
 		}
 	}
-	sort.Strings(alive)
-	return alive
 }
 
 // Let http.Handle() call
 //
 // Runs at /_geerpc_/registry
+//
+// GET answers alive servers, as ServerList JSON if the request accepts application/json,
+// otherwise in headers for old clients. Query "namespace", "service" and "tag" scope servers
+// by their namespace, the service they serve and their tags, e.g. "?namespace=prod&service=Foo&tag=ssd".
+// Servers which don't report their services never match a service.
+// With the watch query, e.g. "?watch=1&revision=42&timeout=30s", GET is a long poll:
+// it answers once the revision of alive servers differs from revision or timeout elapses,
+// timeout is capped at 30s.
+//
+// POST registers a server or keeps it alive, with a ServerItem JSON body,
+// or in headers for old servers. With X-Geerpc-Replicated, POST applies records
+// replicated by a peer registry.
+//
+// DELETE deregisters the server given by query "addr", e.g. "?addr=tcp@localhost:9999".
 func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		// keep it simple, server is in req.Header
-		w.Header().Set("X-Geerpc-Servers", strings.Join(r.aliveServers(), ","))
+		query := req.URL.Query()
+		if query.Get("watch") != "" {
+			revision, err := strconv.ParseUint(query.Get("revision"), 10, 64)
+			if err != nil && query.Get("revision") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			timeout := defaultWatchTimeout
+			if v := query.Get("timeout"); v != "" {
+				if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+			r.wait(req.Context(), revision, timeout)
+		}
+		alive, revision := r.aliveServers(&lookup{
+			namespace: query.Get("namespace"),
+			service:   query.Get("service"),
+			tags:      query["tag"],
+		})
+		w.Header().Set("X-Geerpc-Revision", strconv.FormatUint(revision, 10))
+		if acceptJSON(req) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(&ServerList{Revision: revision, Servers: alive})
+			return
+		}
+		writeHeader(w.Header(), alive)
 	case "POST":
-		addr := req.Header.Get("X-Geerpc-Servers")
+		if req.Header.Get("X-Geerpc-Replicated") != "" {
+			var records []replicaRecord
+			if err := json.NewDecoder(req.Body).Decode(&records); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.applyReplicated(records)
+			return
+		}
+		var item ServerItem
+		var err error
+		if isJSON(req.Header.Get("Content-Type")) {
+			err = json.NewDecoder(req.Body).Decode(&item)
+		} else {
+			item, err = readHeader(req.Header)
+		}
+		if err != nil || item.validate() != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.putServer(item)
+	case "DELETE":
+		// the server is in the addr query, or in X-Geerpc-Servers like POST
+		addr := req.URL.Query().Get("addr")
+		if addr == "" {
+			addr = req.Header.Get("X-Geerpc-Servers")
+		}
 		if addr == "" {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		r.putServer(addr)
+		if !r.deleteServer(addr) {
+			w.WriteHeader(http.StatusNotFound)
+		}
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-// HandleHTTP registers an HTTP handler for GeeRegistry messages on registryPath.
-func (r *GeeRegistry) HandleHTTP(registryPath string) {
-	http.Handle(registryPath, r)
-	log.Println("rpc registry path:", registryPath)
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	return err == nil && t == "application/json"
 }
 
-func HandleHTTP() {
-	DefaultGeeRegister.HandleHTTP(defaultPath)
+func acceptJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if isJSON(strings.TrimSpace(accept)) {
+			return true
+		}
+	}
+	return req.URL.Query().Get("format") == "json"
 }
 
-// Heartbeat send a heartbeat message every once in a while
-// it's a helper function for a server to registry or send heartbeat
-func Heartbeat(registry, addr string, duration time.Duration) {
-	if duration == 0 {
-		// make sure there is enough time to send heartbeat before it moved from registry.
-		duration = defaultTimeout - time.Duration(1)*time.Minute
+// writeHeader writes servers in the header format of old clients, X-Geerpc-Weights and
+// X-Geerpc-Labels list the weight and labels of servers in the same order as X-Geerpc-Servers,
+// labels are url-encoded. Unhealthy servers are left out, the format can't mark them.
+func writeHeader(header http.Header, servers []ServerItem) {
+	addrs := make([]string, 0, len(servers))
+	weights := make([]string, 0, len(servers))
+	labels := make([]string, 0, len(servers))
+	for i := range servers {
+		if servers[i].Unhealthy {
+			continue
+		}
+		addrs = append(addrs, servers[i].Addr)
+		weights = append(weights, strconv.Itoa(servers[i].Weight))
+		labels = append(labels, encodeLabels(servers[i].AllLabels()))
 	}
-	var err error
-	err = sendHeartBeat(registry, addr)
+	header.Set("X-Geerpc-Servers", strings.Join(addrs, ","))
+	header.Set("X-Geerpc-Weights", strings.Join(weights, ","))
+	header.Set("X-Geerpc-Labels", strings.Join(labels, ","))
+}
 
-	// keep send heartbeat until err occur
-	go func() {
-		t := time.NewTicker(duration)
-		for err == nil {
-			<-t.C
-			err = sendHeartBeat(registry, addr)
+// readHeader reads the server registered by an old server in the header format.
+func readHeader(header http.Header) (ServerItem, error) {
+	item := ServerItem{Addr: header.Get("X-Geerpc-Servers"), Namespace: header.Get("X-Geerpc-Namespace")}
+	if v := header.Get("X-Geerpc-Weight"); v != "" {
+		weight, err := strconv.Atoi(v)
+		if err != nil {
+			return item, err
+		}
+		item.Weight = weight
+	}
+	if v := header.Get("X-Geerpc-TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return item, err
 		}
-	}()
+		item.TTL = ttl
+	}
+	labels, err := decodeLabels(header.Get("X-Geerpc-Labels"))
+	item.Labels = labels
+	return item, err
 }
 
-func sendHeartBeat(registry, addr string) error {
-	log.Println(addr, "send heartbeat to registry", registry)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
-	req.Header.Set("X-Geerpc-Servers", addr)
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heartbeat err:", err)
-		return err
+// encodeLabels encodes labels like a url query, e.g. "canary=true&zone=a",
+// so they can't contain the "," separating servers in headers.
+func encodeLabels(labels map[string]string) string {
+	values := make(url.Values, len(labels))
+	for k, v := range labels {
+		values.Set(k, v)
 	}
-	return nil
+	return values.Encode()
+}
+
+func decodeLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(values))
+	for k := range values {
+		labels[k] = values.Get(k)
+	}
+	return labels, nil
+}
+
+// HandleHTTP registers an HTTP handler for GeeRegistry messages on registryPath.
+func (r *GeeRegistry) HandleHTTP(registryPath string) {
+	http.Handle(registryPath, r)
+	log.Println("rpc registry path:", registryPath)
+}
+
+func HandleHTTP() {
+	DefaultGeeRegister.HandleHTTP(defaultPath)
 }
@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func register(registry string, item ServerItem) {
+	err := sendHeartBeat(context.Background(), http.DefaultClient, registry, item)
+	_assert(err == nil, "register error: %v", err)
+}
+
+func getServers(url string) *ServerList {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "get error: %v", err)
+	defer func() { _ = resp.Body.Close() }()
+	var list ServerList
+	_assert(json.NewDecoder(resp.Body).Decode(&list) == nil, "decode error")
+	return &list
+}
+
+func TestGeeRegistry(t *testing.T) {
+	ts := httptest.NewServer(New(time.Minute))
+	defer ts.Close()
+
+	register(ts.URL, ServerItem{
+		Addr:     "tcp@a",
+		Services: []string{"Foo", "Bar"},
+		Weight:   2,
+		Zone:     "z1",
+		Tags:     []string{"ssd", "gpu"},
+	})
+	register(ts.URL, ServerItem{Addr: "tcp@b", Services: []string{"Foo"}, Tags: []string{"ssd"}})
+
+	t.Run("json", func(t *testing.T) {
+		list := getServers(ts.URL)
+		_assert(len(list.Servers) == 2 && list.Revision != 0, "expect 2 servers, got %+v", list)
+		a := list.Servers[0]
+		_assert(a.Addr == "tcp@a" && a.Weight == 2 && a.Zone == "z1" && len(a.Services) == 2,
+			"expect metadata of tcp@a, got %+v", a)
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		list := getServers(ts.URL + "?service=Bar")
+		_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@a", "expect tcp@a serving Bar, got %+v", list)
+		list = getServers(ts.URL + "?service=Foo&tag=ssd")
+		_assert(len(list.Servers) == 2, "expect 2 servers serving Foo with ssd, got %+v", list)
+		list = getServers(ts.URL + "?tag=ssd&tag=gpu")
+		_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@a", "expect tcp@a with ssd and gpu, got %+v", list)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", ts.URL, nil)
+		req.Header.Set("X-Geerpc-Servers", "tcp@c")
+		req.Header.Set("X-Geerpc-Weight", "3")
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil && resp.StatusCode == http.StatusOK, "header post error: %v", err)
+		_ = resp.Body.Close()
+
+		resp, err = http.Get(ts.URL)
+		_assert(err == nil, "get error: %v", err)
+		_ = resp.Body.Close()
+		servers := resp.Header.Get("X-Geerpc-Servers")
+		_assert(servers == "tcp@a,tcp@b,tcp@c", "expect servers in header, got %s", servers)
+		weights := resp.Header.Get("X-Geerpc-Weights")
+		_assert(weights == "2,0,3", "expect weights in header, got %s", weights)
+		labels := resp.Header.Get("X-Geerpc-Labels")
+		_assert(labels == "zone=z1,,", "expect labels in header, got %s", labels)
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		body, _ := json.Marshal(&ServerItem{Addr: "tcp@d", Weight: -1})
+		resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(body))
+		_assert(err == nil && resp.StatusCode == http.StatusBadRequest, "expect 400 for negative weight, got %v", err)
+		_ = resp.Body.Close()
+	})
+}
+
+func TestGeeRegistryExpire(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	register(ts.URL, ServerItem{Addr: "tcp@a"})
+	register(ts.URL, ServerItem{Addr: "tcp@b", TTL: time.Millisecond * 100})
+	list := getServers(ts.URL)
+	_assert(len(list.Servers) == 2, "expect 2 servers, got %+v", list)
+
+	t.Run("ttl", func(t *testing.T) {
+		// the janitor expires tcp@b and wakes up the watch
+		start := time.Now()
+		list = getServers(fmt.Sprintf("%s?watch=1&revision=%d", ts.URL, list.Revision))
+		_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@a", "expect tcp@b expired, got %+v", list)
+		_assert(time.Since(start) < time.Second, "expect expiry notified at once, took %s", time.Since(start))
+	})
+
+	t.Run("deregister", func(t *testing.T) {
+		_assert(Deregister(ts.URL, "tcp@a") == nil, "deregister error")
+		list = getServers(ts.URL)
+		_assert(len(list.Servers) == 0, "expect no server, got %+v", list)
+		_assert(Deregister(ts.URL, "tcp@a") == nil, "expect deregistering twice ok")
+	})
+}
+
+func TestGeeRegistryScope(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	services := []string{"Foo"}
+	h := NewHeartbeater(ts.URL, ServerItem{Addr: "tcp@a", Namespace: "prod"}, &HeartbeatOption{
+		Services: func() []string { return services },
+	})
+	_ = h.Start()
+	defer func() { _ = h.Stop() }()
+	register(ts.URL, ServerItem{Addr: "tcp@b", Services: []string{"Foo", "Bar"}})
+
+	list := getServers(ts.URL + "?namespace=prod")
+	_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@a", "expect tcp@a in prod, got %+v", list)
+	list = getServers(ts.URL + "?namespace=" + DefaultNamespace + "&service=Foo")
+	_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@b", "expect tcp@b in default namespace, got %+v", list)
+	list = getServers(ts.URL + "?service=Bar")
+	_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@b", "expect tcp@b serving Bar, got %+v", list)
+	list = getServers(ts.URL + "?service=Foo")
+	_assert(len(list.Servers) == 2, "expect services reported by the heartbeater, got %+v", list)
+}
@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ReplicationOption configures the replication of a GeeRegistry to its peers.
+//
+// Every registration, heartbeat and deregistration is pushed to peers as soon as it happens,
+// and the whole state is pushed every Interval to repair lost pushes, so all nodes
+// converge eventually. Wall clocks of nodes are assumed roughly in sync.
+type ReplicationOption struct {
+	Peers     []string      // registry URLs of the other nodes
+	Interval  time.Duration // time between two full pushes (anti-entropy)
+	Timeout   time.Duration // timeout of a single push
+	QueueSize int           // records waiting to be pushed to a peer, more are dropped until the next full push
+	Client    *http.Client  // nil means http.DefaultClient
+}
+
+var DefaultReplicationOption = &ReplicationOption{
+	Interval:  time.Second * 30,
+	Timeout:   time.Second * 5,
+	QueueSize: 1024,
+}
+
+// replicaRecord is a registration, heartbeat or deregistration replicated to peers.
+type replicaRecord struct {
+	Item    *ServerItem `json:"item,omitempty"`
+	Addr    string      `json:"addr,omitempty"` // deregistered server
+	Time    time.Time   `json:"time"`           // time of the heartbeat or deregistration
+	Deleted bool        `json:"deleted,omitempty"`
+}
+
+type replicator struct {
+	r     *GeeRegistry
+	opt   *ReplicationOption
+	peers map[string]chan replicaRecord // queue of every peer
+}
+
+// EnableReplication starts replicating registrations to peers and accepting theirs,
+// every node of the cluster lists the others in opt.Peers. Zero fields of opt are taken
+// from DefaultReplicationOption, opt isn't modified.
+// It should be called once before r is used, replication stops when r is closed.
+func (r *GeeRegistry) EnableReplication(opt *ReplicationOption) {
+	if opt == nil {
+		opt = DefaultReplicationOption
+	}
+	o := *opt
+	if o.Interval <= 0 {
+		o.Interval = DefaultReplicationOption.Interval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultReplicationOption.Timeout
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultReplicationOption.QueueSize
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	rep := &replicator{r: r, opt: &o, peers: make(map[string]chan replicaRecord)}
+	for _, peer := range o.Peers {
+		queue := make(chan replicaRecord, o.QueueSize)
+		rep.peers[peer] = queue
+		go rep.run(peer, queue)
+	}
+	r.replicator = rep
+}
+
+// replicate queues rec to every peer.
+func (r *GeeRegistry) replicate(rec replicaRecord) {
+	if r.replicator == nil {
+		return
+	}
+	for peer, queue := range r.replicator.peers {
+		select {
+		case queue <- rec:
+		default:
+			log.Println("rpc registry: replication queue of", peer, "is full, wait for the next full push")
+		}
+	}
+}
+
+// applyReplicated applies records pushed by a peer, they aren't replicated again.
+func (r *GeeRegistry) applyReplicated(records []replicaRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rec := range records {
+		switch {
+		case rec.Deleted:
+			r.remove(rec.Addr, rec.Time)
+		case rec.Item != nil && rec.Item.Addr != "":
+			r.put(*rec.Item, rec.Time)
+		}
+	}
+}
+
+// snapshot returns records of the whole state, alive servers and deletions.
+func (r *GeeRegistry) snapshot() []replicaRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	records := make([]replicaRecord, 0, len(r.servers)+len(r.tombstones))
+	for _, s := range r.servers {
+		if s.Provisional {
+			continue // not confirmed by a heartbeat yet
+		}
+		item := *s
+		records = append(records, replicaRecord{Item: &item, Time: s.start})
+	}
+	for addr, deleted := range r.tombstones {
+		records = append(records, replicaRecord{Addr: addr, Time: deleted, Deleted: true})
+	}
+	return records
+}
+
+// run pushes queued records to peer as they come, and the whole state every Interval.
+func (rep *replicator) run(peer string, queue chan replicaRecord) {
+	t := time.NewTicker(rep.opt.Interval)
+	defer t.Stop()
+	for {
+		var records []replicaRecord
+		select {
+		case <-rep.r.done:
+			return
+		case rec := <-queue:
+			records = append(records, rec)
+			for len(queue) > 0 {
+				records = append(records, <-queue)
+			}
+		case <-t.C:
+			records = rep.r.snapshot()
+		}
+		if err := rep.push(peer, records); err != nil {
+			log.Println("rpc registry: replicate to", peer, "error:", err)
+		}
+	}
+}
+
+func (rep *replicator) push(peer string, records []replicaRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rep.opt.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", peer, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Geerpc-Replicated", "1")
+	resp, err := rep.opt.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc registry: replicate status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitServers waits until the registry at url has n servers.
+func waitServers(url string, n int) *ServerList {
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		list := getServers(url)
+		if len(list.Servers) == n || time.Now().After(deadline) {
+			return list
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	var nodes []*GeeRegistry
+	var urls []string
+	for i := 0; i < 3; i++ {
+		r := New(time.Minute)
+		defer func() { _ = r.Close() }()
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+		nodes = append(nodes, r)
+		urls = append(urls, ts.URL)
+	}
+	// the last node joins later
+	for i := 0; i < 2; i++ {
+		nodes[i].EnableReplication(&ReplicationOption{Peers: []string{urls[1-i]}})
+	}
+
+	register(urls[0], ServerItem{Addr: "tcp@a", Zone: "z1"})
+	list := waitServers(urls[1], 1)
+	_assert(len(list.Servers) == 1 && list.Servers[0].Zone == "z1", "expect tcp@a replicated, got %+v", list)
+
+	_ = Deregister(urls[1], "tcp@a")
+	list = waitServers(urls[0], 0)
+	_assert(len(list.Servers) == 0, "expect tcp@a deregistered on all nodes, got %+v", list)
+
+	register(urls[0], ServerItem{Addr: "tcp@b"})
+	t.Run("anti-entropy", func(t *testing.T) {
+		opt := &ReplicationOption{Peers: []string{urls[2]}, Interval: time.Millisecond * 50}
+		nodes[1].EnableReplication(opt)
+		list := waitServers(urls[2], 1)
+		_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@b", "expect full state pushed, got %+v", list)
+	})
+
+	t.Run("late heartbeat", func(t *testing.T) {
+		// a heartbeat replicated after the deregistration doesn't bring the server back
+		nodes[0].applyReplicated([]replicaRecord{{Item: &ServerItem{Addr: "tcp@a"}, Time: time.Now().Add(-time.Second)}})
+		list := getServers(urls[0])
+		_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@b", "expect tcp@a kept deleted, got %+v", list)
+	})
+}
+
+func TestEnableReplication_Option(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	r.EnableReplication(nil)
+
+	r2 := New(time.Minute)
+	defer func() { _ = r2.Close() }()
+	opt := &ReplicationOption{Interval: time.Hour}
+	r2.EnableReplication(opt)
+	_assert(opt.Timeout == 0 && opt.QueueSize == 0 && opt.Client == nil, "expect opt of the caller unchanged, got %+v", opt)
+	_assert(DefaultReplicationOption.Client == nil, "expect DefaultReplicationOption unchanged")
+}
@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Methods of the Registry service, see GeeRegistry.Service.
+const (
+	RegisterMethod   = "Registry.Register"
+	DeregisterMethod = "Registry.Deregister"
+	ListMethod       = "Registry.List"
+	WatchMethod      = "Registry.Watch"
+)
+
+// ListArgs scopes the servers answered by List and Watch, "" or empty means any.
+type ListArgs struct {
+	Namespace string
+	Service   string
+	Tags      []string // servers must have all tags
+}
+
+type WatchArgs struct {
+	ListArgs
+	Revision uint64        // revision known by the caller, Watch returns once the revision differs
+	Timeout  time.Duration // max time to wait, 0 means defaultWatchTimeout, which is also the cap
+}
+
+// Registry is the geerpc service of a GeeRegistry, so servers and clients can talk
+// to the registry over geerpc like over HTTP. Registrations are replicated and
+// persisted the same way.
+type Registry struct {
+	r    *GeeRegistry
+	once sync.Once // close done only once
+	done chan struct{}
+}
+
+// Service returns the geerpc service of r, see RegisterService. A service registered
+// by hand should be closed when its server shuts down, so pending watches end, e.g.
+//
+//	svc := r.Service()
+//	_ = server.Register(svc)
+//	server.RegisterOnShutdown(func() { _ = svc.Close() })
+func (r *GeeRegistry) Service() *Registry {
+	return &Registry{r: r, done: make(chan struct{})}
+}
+
+// RegisterService registers the geerpc service of r to server,
+// pending watches end when server shuts down.
+func (r *GeeRegistry) RegisterService(server *geerpc.Server) error {
+	svc := r.Service()
+	if err := server.Register(svc); err != nil {
+		return err
+	}
+	server.RegisterOnShutdown(func() { _ = svc.Close() })
+	return nil
+}
+
+// Close ends pending watches, which answer the current servers, and later watches don't wait.
+func (s *Registry) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return nil
+}
+
+// Register registers item or refreshes it like a heartbeat.
+func (s *Registry) Register(item ServerItem, ok *bool) error {
+	if err := item.validate(); err != nil {
+		return err
+	}
+	s.r.putServer(item)
+	*ok = true
+	return nil
+}
+
+// Deregister removes the server at addr, ok reports whether it was registered.
+func (s *Registry) Deregister(addr string, ok *bool) error {
+	if addr == "" {
+		return errors.New("rpc registry: missing server address")
+	}
+	*ok = s.r.deleteServer(addr)
+	return nil
+}
+
+// List answers alive servers in the scope of args.
+func (s *Registry) List(args ListArgs, reply *ServerList) error {
+	reply.Servers, reply.Revision = s.r.aliveServers(args.lookup())
+	return nil
+}
+
+// Watch blocks until the revision of servers differs from args.Revision, args.Timeout elapses,
+// or s or its registry is closed, then answers alive servers in the scope of args.
+func (s *Registry) Watch(args WatchArgs, reply *ServerList) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	s.r.wait(ctx, args.Revision, args.Timeout)
+	return s.List(args.ListArgs, reply)
+}
+
+func (args *ListArgs) lookup() *lookup {
+	return &lookup{namespace: args.Namespace, service: args.Service, tags: args.Tags}
+}
+
+// IsRPCAddr reports whether registry is the geerpc address of a Registry service,
+// in the protocol@addr format of geerpc.XDial, rather than the URL of a GeeRegistry.
+func IsRPCAddr(registry string) bool {
+	return !strings.Contains(registry, "://") && strings.Contains(registry, "@")
+}
+
+// Client calls the Registry service at a geerpc address, it connects on the first call
+// and reconnects when the connection breaks.
+type Client struct {
+	addr   string
+	opt    *geerpc.Option
+	mu     sync.Mutex // protect following
+	client *geerpc.Client
+}
+
+// NewClient creates a Client of the Registry service at addr, nil opt means geerpc.DefaultOption.
+func NewClient(addr string, opt *geerpc.Option) *Client {
+	return &Client{addr: addr, opt: opt}
+}
+
+func (c *Client) Register(ctx context.Context, item ServerItem) error {
+	var ok bool
+	return c.call(ctx, RegisterMethod, item, &ok)
+}
+
+// Deregister removes the server at addr, it's not an error if the server is unknown.
+func (c *Client) Deregister(ctx context.Context, addr string) error {
+	var ok bool
+	return c.call(ctx, DeregisterMethod, addr, &ok)
+}
+
+func (c *Client) List(ctx context.Context, args ListArgs) (*ServerList, error) {
+	var list ServerList
+	if err := c.call(ctx, ListMethod, args, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (c *Client) Watch(ctx context.Context, args WatchArgs) (*ServerList, error) {
+	var list ServerList
+	if err := c.call(ctx, WatchMethod, args, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Close closes the connection, a later call connects again.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+func (c *Client) call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, serviceMethod, args, reply)
+}
+
+func (c *Client) dial() (*geerpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil && !c.client.IsAvailable() {
+		_ = c.client.Close()
+		c.client = nil
+	}
+	if c.client == nil {
+		client, err := geerpc.XDial(c.addr, c.opt)
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
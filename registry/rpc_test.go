@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"geerpc"
+	"net"
+	"testing"
+	"time"
+)
+
+// startService serves the Registry service of r over geerpc, returning its address.
+func startService(r *GeeRegistry) (string, func()) {
+	server := geerpc.NewServer()
+	_ = r.RegisterService(server)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String(), func() { _ = server.Shutdown(context.Background()) }
+}
+
+func TestRegistryService(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	addr, stop := startService(r)
+	defer stop()
+	c := NewClient(addr, nil)
+	defer func() { _ = c.Close() }()
+	ctx := context.Background()
+
+	h := NewHeartbeater(addr, ServerItem{Addr: "tcp@a", Namespace: "prod", Services: []string{"Foo"}}, nil)
+	_assert(h.Start() == nil, "expect heartbeat over geerpc, got %v", h.Status().Err)
+	_assert(c.Register(ctx, ServerItem{Addr: "tcp@b", Weight: 2}) == nil, "register error")
+	_assert(c.Register(ctx, ServerItem{Addr: "tcp@c", Weight: -1}) != nil, "expect error for negative weight")
+
+	list, err := c.List(ctx, ListArgs{})
+	_assert(err == nil && len(list.Servers) == 2 && list.Revision != 0, "expect 2 servers, got %+v %v", list, err)
+	list, _ = c.List(ctx, ListArgs{Namespace: "prod", Service: "Foo"})
+	_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@a", "expect tcp@a serving Foo in prod, got %+v", list)
+
+	t.Run("watch", func(t *testing.T) {
+		start := time.Now()
+		list, _ = c.Watch(ctx, WatchArgs{Revision: list.Revision, Timeout: time.Millisecond * 100})
+		_assert(time.Since(start) >= time.Millisecond*100 && len(list.Servers) == 2, "expect watch timed out, got %+v", list)
+
+		go func() {
+			time.Sleep(time.Millisecond * 50)
+			_ = h.Stop()
+		}()
+		list, _ = c.Watch(ctx, WatchArgs{Revision: list.Revision})
+		_assert(len(list.Servers) == 1 && list.Servers[0].Addr == "tcp@b", "expect tcp@a deregistered, got %+v", list)
+	})
+
+	_assert(Deregister(addr, "tcp@b") == nil, "deregister error")
+	list, _ = c.List(ctx, ListArgs{})
+	_assert(len(list.Servers) == 0, "expect no server, got %+v", list)
+}
+
+func TestRegistryService_WatchShutdown(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	addr, stop := startService(r)
+	c := NewClient(addr, nil)
+	defer func() { _ = c.Close() }()
+	list, _ := c.List(context.Background(), ListArgs{})
+
+	watched := make(chan error)
+	go func() {
+		_, err := c.Watch(context.Background(), WatchArgs{Revision: list.Revision, Timeout: time.Hour})
+		watched <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	start := time.Now()
+	stop()
+	_assert(time.Since(start) < time.Second, "expect shutdown not delayed by the pending watch")
+	select {
+	case err := <-watched:
+		_assert(err == nil, "expect the pending watch answered, got %v", err)
+	case <-time.After(time.Second):
+		_assert(false, "expect the pending watch ended by shutdown")
+	}
+}
@@ -0,0 +1,38 @@
+package registry
+
+import (
+	"geerpc"
+	"log"
+	"net"
+)
+
+// RegisterServer registers server listening on lis to registry and keeps it alive by heartbeats,
+// the server is deregistered when it's shut down by Server.Shutdown.
+//
+// The address is server.AdvertiseAddr(lis) unless item.Addr is set, call server.AdvertiseHTTP(lis)
+// before if lis is served by HTTP. Services are reported from server.Services before every
+// heartbeat unless opt.Services is set. Other metadata of item is registered as is,
+// nil opt means DefaultHeartbeatOption.
+//
+// It returns the error of the first heartbeat, which is retried anyway.
+func RegisterServer(server *geerpc.Server, lis net.Listener, registry string, item ServerItem,
+	opt *HeartbeatOption) (*Heartbeater, error) {
+	if item.Addr == "" {
+		item.Addr = server.AdvertiseAddr(lis)
+	}
+	hOpt := HeartbeatOption{}
+	if opt != nil {
+		hOpt = *opt
+	}
+	if hOpt.Services == nil {
+		hOpt.Services = server.Services
+	}
+
+	h := NewHeartbeater(registry, item, &hOpt)
+	server.RegisterOnShutdown(func() {
+		if err := h.Stop(); err != nil {
+			log.Println("rpc registry: deregister", item.Addr, "error:", err)
+		}
+	})
+	return h, h.Start()
+}
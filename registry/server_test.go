@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"context"
+	"geerpc"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+func (f Foo) Sum(args [2]int, reply *int) error {
+	*reply = args[0] + args[1]
+	return nil
+}
+
+func TestRegisterServer(t *testing.T) {
+	r := New(time.Minute)
+	defer func() { _ = r.Close() }()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	server := geerpc.NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	_, err := RegisterServer(server, l, ts.URL, ServerItem{Zone: "z1"}, nil)
+	_assert(err == nil, "register server error: %v", err)
+	list := getServers(ts.URL + "?service=Foo")
+	_assert(len(list.Servers) == 1, "expect server registered with its services, got %+v", list)
+	s := list.Servers[0]
+	_assert(s.Addr == "tcp@"+l.Addr().String() && s.Zone == "z1", "expect tcp address and metadata, got %+v", s)
+
+	_ = server.Shutdown(context.Background())
+	list = getServers(ts.URL)
+	_assert(len(list.Servers) == 0, "expect server deregistered on shutdown, got %+v", list)
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	server := geerpc.NewServer()
+	l, _ := net.Listen("tcp", ":0")
+	defer func() { _ = l.Close() }()
+	addr := server.AdvertiseAddr(l)
+	_assert(strings.HasPrefix(addr, "tcp@") && !strings.HasPrefix(addr, "tcp@[::]") && !strings.HasPrefix(addr, "tcp@:"),
+		"expect an interface address, got %s", addr)
+
+	hl, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = hl.Close() }()
+	server.AdvertiseHTTP(hl)
+	addr = server.AdvertiseAddr(hl)
+	_assert(addr == "http@"+hl.Addr().String(), "expect http address of the HTTP listener, got %s", addr)
+	addr = server.AdvertiseAddr(l)
+	_assert(strings.HasPrefix(addr, "tcp@"), "expect tcp address kept for the other listener, got %s", addr)
+}
@@ -0,0 +1,213 @@
+package registry
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store persists the registrations of a GeeRegistry, so a restarted registry
+// knows the servers before they heartbeat again.
+//
+// Only changes of registrations are written, heartbeats which just keep a server alive are not.
+type Store interface {
+	Load() ([]ServerItem, error) // registrations saved before, called once on startup
+	Put(item ServerItem) error   // save a new or changed registration
+	Delete(addr string) error    // delete a deregistered or expired server
+	Close() error
+}
+
+// compactThreshold is the number of records in the log of FileStore before it may be compacted.
+const compactThreshold = 1000
+
+type storeRecord struct {
+	Op   string      `json:"op"` // "put" or "delete"
+	Item *ServerItem `json:"item,omitempty"`
+	Addr string      `json:"addr,omitempty"`
+}
+
+// FileStore is a Store writing registrations to an append-only log of JSON lines.
+// When the log grows much larger than the registrations, it's compacted into
+// a snapshot holding only live registrations.
+type FileStore struct {
+	path    string
+	mu      sync.Mutex // protect following
+	f       *os.File
+	live    map[string]ServerItem
+	records int // records in the log
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore opens the log at path, it's created if it doesn't exist.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, live: make(map[string]ServerItem)}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return s, nil
+}
+
+// replay reads the log into s.live. A truncated last record, left by a crash
+// in the middle of a write, is cut off, so new records aren't appended to it.
+func (s *FileStore) replay() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	r := bufio.NewReader(f)
+	var offset int64 // end of the last complete record
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Println("rpc registry: cut truncated record in", s.path)
+				return os.Truncate(s.path, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		var rec storeRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			log.Println("rpc registry: ignore bad record in", s.path, err)
+			continue
+		}
+		switch {
+		case rec.Op == "put" && rec.Item != nil:
+			s.live[rec.Item.Addr] = *rec.Item
+		case rec.Op == "delete":
+			delete(s.live, rec.Addr)
+		}
+		s.records++
+	}
+}
+
+// Load returns the registrations in the log sorted by address.
+func (s *FileStore) Load() ([]ServerItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]ServerItem, 0, len(s.live))
+	for _, item := range s.live {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Addr < items[j].Addr })
+	return items, nil
+}
+
+func (s *FileStore) Put(item ServerItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item.Provisional = false
+	s.live[item.Addr] = item
+	return s.append(&storeRecord{Op: "put", Item: &item})
+}
+
+func (s *FileStore) Delete(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.live[addr]; !ok {
+		return nil
+	}
+	delete(s.live, addr)
+	return s.append(&storeRecord{Op: "delete", Addr: addr})
+}
+
+// append writes rec to the log and compacts the log if needed, s.mu must be held.
+func (s *FileStore) append(rec *storeRecord) error {
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.records++
+	if s.records > compactThreshold && s.records > 2*len(s.live) {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact replaces the log by a snapshot of live registrations, s.mu must be held.
+// The snapshot is written to a temporary file renamed over the log, so a crash
+// leaves either the old log or the snapshot.
+func (s *FileStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // fails once renamed
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for addr := range s.live {
+		item := s.live[addr]
+		if err := enc.Encode(&storeRecord{Op: "put", Item: &item}); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_ = s.f.Close()
+	s.f = f
+	s.records = len(s.live)
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.log")
+	store, _ := NewFileStore(path)
+	r, err := NewWithStore(time.Minute, store)
+	_assert(err == nil, "new registry error: %v", err)
+	ts := httptest.NewServer(r)
+	register(ts.URL, ServerItem{Addr: "tcp@a", Weight: 2})
+	register(ts.URL, ServerItem{Addr: "tcp@b"})
+	register(ts.URL, ServerItem{Addr: "tcp@c"})
+	_ = Deregister(ts.URL, "tcp@c")
+	ts.Close()
+	_ = r.Close()
+
+	// a crash in the middle of a write leaves a truncated record
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.WriteString(`{"op":"put","item":{"addr":"tcp@d"`)
+	_ = f.Close()
+
+	t.Run("recovery", func(t *testing.T) {
+		store, err := NewFileStore(path)
+		_assert(err == nil, "reopen store error: %v", err)
+		r, _ := NewWithStore(time.Minute, store)
+		defer func() { _ = r.Close() }()
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		list := getServers(ts.URL)
+		_assert(len(list.Servers) == 2, "expect tcp@a and tcp@b reloaded, got %+v", list)
+		a := list.Servers[0]
+		_assert(a.Addr == "tcp@a" && a.Weight == 2 && a.Provisional, "expect provisional tcp@a, got %+v", a)
+
+		register(ts.URL, ServerItem{Addr: "tcp@a", Weight: 2})
+		list = getServers(ts.URL)
+		_assert(!list.Servers[0].Provisional && list.Servers[1].Provisional,
+			"expect tcp@a confirmed by heartbeat, got %+v", list)
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		store, _ := NewFileStore(path)
+		defer func() { _ = store.Close() }()
+		for i := 0; i <= compactThreshold; i++ {
+			_ = store.Put(ServerItem{Addr: "tcp@e", Weight: i})
+		}
+		_assert(store.records < 10, "expect log compacted, got %d records", store.records)
+
+		reopened, _ := NewFileStore(path)
+		items, _ := reopened.Load()
+		_ = reopened.Close()
+		_assert(len(items) == 3 && items[2].Weight == compactThreshold, "expect live items kept, got %+v", items)
+	})
+}
@@ -1,6 +1,8 @@
 package geerpc
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,8 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,7 +31,7 @@ type Option struct {
 	MagicNumber    int           // MagicNumber marks this's a geerpc request
 	CodecType      codec.Type    // client may choose diff Codec to encode body
 	ConnectTimeout time.Duration // 0 means no limit
-	HandleTimeout  time.Duration
+	HandleTimeout  time.Duration // 0 means ConnectTimeout, as older clients expect
 }
 
 var DefaultOption = &Option{
@@ -39,14 +43,70 @@ var DefaultOption = &Option{
 // Server represents an RPC Server
 type Server struct {
 	serviceMap sync.Map
+	health     *Health
+	mu         sync.Mutex                // protect following
+	listeners  map[net.Listener]struct{} // created lazily, so a zero Server works
+	conns      map[io.Closer]struct{}
+	inflight   int      // requests being handled
+	shutdown   bool     // Shutdown has been called
+	onShutdown []func() // called by Shutdown first
+	// listeners served by HTTP, clients connect to them by HTTP CONNECT, see AdvertiseHTTP.
+	httpListeners map[net.Listener]struct{}
 }
 
+// ErrServerClosed is answered to requests received after Shutdown is called.
+var ErrServerClosed = errors.New("rpc server: server is shutting down")
+
 func (server *Server) Register(rcvr interface{}) error {
+	s, err := server.register(rcvr)
+	if err != nil {
+		return err
+	}
+	s.logMethods()
+	return nil
+}
+
+// register registers rcvr without logging its methods, NewServer registers the
+// built-in services by it, as DefaultServer is created when the package is initialized.
+func (server *Server) register(rcvr interface{}) (*service, error) {
 	s := newService(rcvr)
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
-		return errors.New("rpc: service already defined: " + s.name)
+		return nil, errors.New("rpc: service already defined: " + s.name)
 	}
-	return nil
+	if server.health != nil {
+		server.health.SetServingStatus(s.name, StatusServing)
+	}
+	return s, nil
+}
+
+// Health returns the built-in health service of server, applications set serving status by it.
+func (server *Server) Health() *Health {
+	return server.health
+}
+
+// Services returns the sorted names of services registered on server,
+// without the built-in Health and Reflection services every server has.
+func (server *Server) Services() []string {
+	var names []string
+	server.serviceMap.Range(func(nameI, svcI interface{}) bool {
+		if !server.builtin(svcI.(*service)) {
+			names = append(names, nameI.(string))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// builtin reports whether svc is one of the built-in services registered by NewServer.
+func (server *Server) builtin(svc *service) bool {
+	switch rcvr := svc.rcvr.Interface().(type) {
+	case *Health:
+		return rcvr == server.health
+	case *Reflection:
+		return rcvr.server == server
+	}
+	return false
 }
 
 func Register(rcvr interface{}) error {
@@ -75,9 +135,12 @@ func (server *Server) findService(serviceMethod string) (svc *service, mType *me
 	return
 }
 
-// NewServer returns a new Server
+// NewServer returns a new Server, with the built-in Health and Reflection services registered.
 func NewServer() *Server {
-	return &Server{}
+	server := &Server{health: newHealth()}
+	_, _ = server.register(server.health)
+	_, _ = server.register(&Reflection{server: server})
+	return server
 }
 
 // DefaultServer is the default instance of *Server
@@ -85,26 +148,166 @@ var DefaultServer = NewServer()
 
 // accept all income connection and create an goroutine to handle
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		return
+	}
+	defer server.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if !server.shuttingDown() {
+				log.Println("rpc server: accept error:", err)
+			}
 			return
 		}
 		go server.ServerConn(conn)
 	}
 }
 
+// trackListener adds or removes lis, it returns false when adding after Shutdown.
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.shutdown {
+		_ = lis.Close()
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn adds or removes conn, it returns false when adding after Shutdown.
+func (server *Server) trackConn(conn io.Closer, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if !add {
+		delete(server.conns, conn)
+		return true
+	}
+	if server.shutdown {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[io.Closer]struct{})
+	}
+	server.conns[conn] = struct{}{}
+	return true
+}
+
+func (server *Server) shuttingDown() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.shutdown
+}
+
+// startRequest counts a request in flight, it returns false after Shutdown.
+func (server *Server) startRequest() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.shutdown {
+		return false
+	}
+	server.inflight++
+	return true
+}
+
+func (server *Server) finishRequest() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.inflight--
+}
+
+// waitRequests waits until all requests in flight are handled or ctx expires.
+func (server *Server) waitRequests(ctx context.Context) error {
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	for {
+		server.mu.Lock()
+		inflight := server.inflight
+		server.mu.Unlock()
+		if inflight == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// how often Shutdown checks whether all requests are handled
+const shutdownPollInterval = time.Millisecond * 100
+
+// RegisterOnShutdown registers a function to call when Shutdown is called,
+// e.g. to deregister the server from a registry before it stops accepting requests.
+func (server *Server) RegisterOnShutdown(f func()) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.onShutdown = append(server.onShutdown, f)
+}
+
+// Shutdown gracefully shuts down the server. It calls the functions registered by
+// RegisterOnShutdown, flips the health status of all services to NOT_SERVING, closes
+// all listeners, waits for requests in flight to be handled, and then closes all connections.
+// Requests received meanwhile are answered ErrServerClosed.
+//
+// If ctx expires before all requests are handled, Shutdown closes connections and returns ctx.Err().
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	onShutdown := server.onShutdown
+	server.onShutdown = nil // call them only once
+	server.mu.Unlock()
+	for _, f := range onShutdown {
+		f()
+	}
+
+	if server.health != nil {
+		server.health.Shutdown()
+	}
+
+	server.mu.Lock()
+	server.shutdown = true
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	server.mu.Unlock()
+
+	err := server.waitRequests(ctx)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for conn := range server.conns {
+		_ = conn.Close()
+	}
+	return err
+}
+
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 
 // ServerConn runs the server on a single connection.
 // ServerConn blocks, serving the connection until the client hangs up.
 func (server *Server) ServerConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
+	if !server.trackConn(conn, true) {
+		return
+	}
+	defer server.trackConn(conn, false)
 
 	var opt Option
 
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Println("rpc server: options error:", err)
 		return
 	}
@@ -118,9 +321,28 @@ func (server *Server) ServerConn(conn io.ReadWriteCloser) {
 		return
 	}
 
-	server.ServerCodec(f(conn), opt.ConnectTimeout)
+	// the json decoder may have read ahead the first request, give it back to codec,
+	// without the newline json.Encoder writes after Option.
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	timeout := opt.HandleTimeout
+	if timeout == 0 {
+		timeout = opt.ConnectTimeout
+	}
+	server.ServerCodec(f(&bufferedConn{Reader: r, conn: conn}), timeout)
+}
+
+// bufferedConn reads from Reader, but writes to and closes conn.
+type bufferedConn struct {
+	io.Reader
+	conn io.ReadWriteCloser
 }
 
+func (c *bufferedConn) Write(p []byte) (int, error) { return c.conn.Write(p) }
+func (c *bufferedConn) Close() error                { return c.conn.Close() }
+
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
@@ -138,6 +360,11 @@ func (server *Server) ServerCodec(cc codec.Codec, timeout time.Duration) {
 			server.sendResponse(cc, req.h, invalidRequest, sending)
 			continue
 		}
+		if !server.startRequest() {
+			req.h.Error = ErrServerClosed.Error()
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			continue
+		}
 		wg.Add(1)
 		go server.handleRequest(cc, req, sending, wg, timeout)
 	}
@@ -199,6 +426,7 @@ The whole process is split into two phases: called and sent. Two condition occur
 */
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
+	defer server.finishRequest()
 	called := make(chan struct{})
 	sent := make(chan struct{})
 	go func() {
@@ -277,3 +505,59 @@ func (server *Server) HandleHTTP() {
 func HandleHTTP() {
 	DefaultServer.HandleHTTP()
 }
+
+// AdvertiseHTTP tells AdvertiseAddr that lis is served by HTTP, e.g. http.Serve(lis, nil)
+// with the handlers of HandleHTTP, so clients connect to it by HTTP CONNECT.
+// Listeners passed to Accept use the geerpc protocol directly, even if HandleHTTP is used.
+func (server *Server) AdvertiseHTTP(lis net.Listener) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.httpListeners == nil {
+		server.httpListeners = make(map[net.Listener]struct{})
+	}
+	server.httpListeners[lis] = struct{}{}
+}
+
+// AdvertiseAddr returns the address clients use to reach server listening on lis,
+// in the protocol@addr format of XDial: http@addr if lis is given to AdvertiseHTTP,
+// otherwise the network of lis, e.g. tcp@addr or unix@path.
+//
+// An unspecified host, e.g. ":9999", is replaced by an address of a network interface.
+func (server *Server) AdvertiseAddr(lis net.Listener) string {
+	server.mu.Lock()
+	_, viaHTTP := server.httpListeners[lis]
+	server.mu.Unlock()
+
+	protocol, addr := lis.Addr().Network(), lis.Addr().String()
+	if viaHTTP {
+		protocol = "http"
+	}
+	if tcpAddr, ok := lis.Addr().(*net.TCPAddr); ok && tcpAddr.IP.IsUnspecified() {
+		addr = net.JoinHostPort(interfaceIP(), strconv.Itoa(tcpAddr.Port))
+	}
+	return protocol + "@" + addr
+}
+
+// interfaceIP returns an IPv4 address of a network interface, loopback addresses
+// come last and 127.0.0.1 is the fallback.
+func interfaceIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	var loopback string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		if !ipNet.IP.IsLoopback() {
+			return ipNet.IP.String()
+		}
+		loopback = ipNet.IP.String()
+	}
+	if loopback != "" {
+		return loopback
+	}
+	return "127.0.0.1"
+}
@@ -0,0 +1,93 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"geerpc/codec"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Baz int
+
+func (b Baz) Echo(args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+// writeBuffer collects what a codec writes.
+type writeBuffer struct {
+	bytes.Buffer
+}
+
+func (b *writeBuffer) Close() error { return nil }
+
+func TestServer_OptionReadAhead(t *testing.T) {
+	server := NewServer()
+	var b Baz
+	_ = server.Register(&b)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	// options and the first request in a single write, the json decoder
+	// of options reads ahead the request.
+	var buf writeBuffer
+	_ = json.NewEncoder(&buf).Encode(DefaultOption)
+	_ = codec.NewGobCodec(&buf).Write(&codec.Header{ServiceMethod: "Baz.Echo", Seq: 1}, 42)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = conn.Close() }()
+	_, _ = conn.Write(buf.Bytes())
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	cc := codec.NewGobCodec(conn)
+	var h codec.Header
+	var reply int
+	err = cc.ReadHeader(&h)
+	if err == nil {
+		err = cc.ReadBody(&reply)
+	}
+	_assert(err == nil && h.Error == "" && reply == 42, "expect the request read ahead served, got %v %q %d", err, h.Error, reply)
+}
+
+func TestServer_ZeroValue(t *testing.T) {
+	server := &Server{}
+	var b Baz
+	_ = server.Register(&b)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Baz.Echo", 7, &reply)
+	_assert(err == nil && reply == 7, "expect a zero Server serving calls, got %v %d", err, reply)
+	_assert(server.Shutdown(context.Background()) == nil, "shutdown error")
+}
+
+func TestServer_Services(t *testing.T) {
+	server := NewServer()
+	var b Baz
+	_ = server.Register(&b)
+	services := server.Services()
+	_assert(len(services) == 1 && services[0] == "Baz", "expect built-in services left out, got %v", services)
+}
+
+func TestServer_RegisterBuiltinQuietly(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	server := NewServer()
+	var b Baz
+	_ = server.Register(&b)
+	out := buf.String()
+	_assert(!strings.Contains(out, "Health.") && !strings.Contains(out, "Reflection."), "expect built-in services not logged, got %q", out)
+	_assert(strings.Contains(out, "register Baz.Echo"), "expect registered service logged, got %q", out)
+}
@@ -85,7 +85,15 @@ func (s *service) registerMethods() {
 			ArgType:   argType,
 			ReplyType: replyType,
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
+	}
+}
+
+// logMethods logs the enrolled methods, in the order of the method set.
+func (s *service) logMethods() {
+	for i := 0; i < s.typ.NumMethod(); i++ {
+		if name := s.typ.Method(i).Name; s.method[name] != nil {
+			log.Printf("rpc server: register %s.%s\n", s.name, name)
+		}
 	}
 }
 
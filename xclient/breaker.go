@@ -0,0 +1,297 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // requests pass through
+	StateOpen                         // requests are rejected until OpenTimeout elapses
+	StateHalfOpen                     // a limited number of probe requests pass through
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrNoAvailableServer means every server is unhealthy or its circuit breaker is open.
+var ErrNoAvailableServer = errors.New("rpc xclient: no available servers, all are unhealthy or broken")
+
+// BreakerOption configures the circuit breaker kept for every server address.
+//
+// A closed breaker trips to open when MaxFailures consecutive calls failed,
+// or when the error rate over the sliding Window reaches ErrorRate.
+// After OpenTimeout it turns half-open and lets HalfOpenMaxCalls probes through,
+// closing again once they all succeed, or re-opening on the first failure.
+type BreakerOption struct {
+	MaxFailures      int           // 0 disables the consecutive failures rule
+	ErrorRate        float64       // 0 disables the error rate rule
+	MinRequests      int           // minimum requests in Window before ErrorRate is checked
+	Window           time.Duration // length of the sliding window
+	OpenTimeout      time.Duration // time to stay open before turning half-open
+	HalfOpenMaxCalls int           // probe requests allowed in half-open state
+	// OnStateChange is called on every state transition, it must not block.
+	OnStateChange func(addr string, from, to BreakerState)
+}
+
+var DefaultBreakerOption = &BreakerOption{
+	MaxFailures:      5,
+	ErrorRate:        0.5,
+	MinRequests:      20,
+	Window:           time.Second * 10,
+	OpenTimeout:      time.Second * 5,
+	HalfOpenMaxCalls: 1,
+}
+
+// number of buckets the sliding window is split into
+const breakerBuckets = 10
+
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// breaker is the circuit breaker of a single server address.
+type breaker struct {
+	addr      string
+	opt       *BreakerOption
+	mu        sync.Mutex // protect following
+	state     BreakerState
+	openedAt  time.Time
+	failures  int // consecutive failures
+	probes    int // probes let through in half-open state
+	successes int // successful probes in half-open state
+	buckets   [breakerBuckets]bucket
+}
+
+func newBreaker(addr string, opt *BreakerOption) *breaker {
+	return &breaker{addr: addr, opt: opt}
+}
+
+// current returns the bucket for now, resetting it if it has slid out of the window.
+func (b *breaker) current(now time.Time) *bucket {
+	size := b.opt.Window / breakerBuckets
+	if size <= 0 {
+		size = time.Second
+	}
+	start := now.Truncate(size)
+	bk := &b.buckets[(start.UnixNano()/int64(size))%breakerBuckets]
+	if !bk.start.Equal(start) {
+		*bk = bucket{start: start}
+	}
+	return bk
+}
+
+// counts sums the requests and failures of all buckets inside the window.
+func (b *breaker) counts(now time.Time) (requests, failures int) {
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < b.opt.Window {
+			requests += bk.requests
+			failures += bk.failures
+		}
+	}
+	return
+}
+
+func (b *breaker) setState(to BreakerState, now time.Time) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	switch to {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.buckets = [breakerBuckets]bucket{}
+	}
+	if b.opt.OnStateChange != nil {
+		b.opt.OnStateChange(b.addr, from, to)
+	}
+}
+
+// refresh turns an open breaker half-open once OpenTimeout has elapsed.
+func (b *breaker) refresh(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.opt.OpenTimeout {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+// ready reports whether a request could pass without reserving it.
+func (b *breaker) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return b.probes < b.opt.HalfOpenMaxCalls
+	default:
+		return true
+	}
+}
+
+// allow reserves a request, a half-open breaker counts it as a probe.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probes >= b.opt.HalfOpenMaxCalls {
+			return false
+		}
+		b.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// done records the result of a request which was allowed before.
+// A request canceled by its caller isn't counted, a half-open breaker lets another probe through.
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if errors.Is(err, context.Canceled) {
+		if b.state == StateHalfOpen && b.probes > 0 {
+			b.probes--
+		}
+		return
+	}
+	switch b.state {
+	case StateHalfOpen:
+		if err != nil {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.opt.HalfOpenMaxCalls {
+			b.setState(StateClosed, now)
+		}
+	case StateClosed:
+		bk := b.current(now)
+		bk.requests++
+		if err == nil {
+			b.failures = 0
+			return
+		}
+		bk.failures++
+		b.failures++
+		if b.opt.MaxFailures > 0 && b.failures >= b.opt.MaxFailures {
+			b.setState(StateOpen, now)
+			return
+		}
+		if b.opt.ErrorRate > 0 {
+			requests, failures := b.counts(now)
+			if requests >= b.opt.MinRequests && float64(failures)/float64(requests) >= b.opt.ErrorRate {
+				b.setState(StateOpen, now)
+			}
+		}
+	}
+}
+
+// BreakerStat is a snapshot of the circuit breaker of a server.
+type BreakerStat struct {
+	Addr     string
+	State    BreakerState
+	Requests int // requests in the sliding window
+	Failures int // failures in the sliding window
+}
+
+// breakerGroup holds the circuit breakers of all servers a XClient has called.
+type breakerGroup struct {
+	opt      *BreakerOption
+	mu       sync.Mutex // protect following
+	breakers map[string]*breaker
+}
+
+// newBreakerGroup creates a group with a copy of opt, whose zero Window, MinRequests, OpenTimeout
+// and HalfOpenMaxCalls are taken from DefaultBreakerOption. Zero MaxFailures and ErrorRate
+// disable their rules.
+func newBreakerGroup(opt *BreakerOption) *breakerGroup {
+	o := *opt
+	if o.MinRequests <= 0 {
+		o.MinRequests = DefaultBreakerOption.MinRequests
+	}
+	if o.Window <= 0 {
+		o.Window = DefaultBreakerOption.Window
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = DefaultBreakerOption.OpenTimeout
+	}
+	if o.HalfOpenMaxCalls <= 0 {
+		o.HalfOpenMaxCalls = DefaultBreakerOption.HalfOpenMaxCalls
+	}
+	return &breakerGroup{
+		opt:      &o,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (g *breakerGroup) get(addr string) *breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, ok := g.breakers[addr]
+	if !ok {
+		b = newBreaker(addr, g.opt)
+		g.breakers[addr] = b
+	}
+	return b
+}
+
+// remove forgets the breaker of addr, e.g. when addr is removed from discovery.
+func (g *breakerGroup) remove(addr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.breakers, addr)
+}
+
+func (g *breakerGroup) stats() []BreakerStat {
+	g.mu.Lock()
+	breakers := make([]*breaker, 0, len(g.breakers))
+	for _, b := range g.breakers {
+		breakers = append(breakers, b)
+	}
+	g.mu.Unlock()
+
+	stats := make([]BreakerStat, 0, len(breakers))
+	now := time.Now()
+	for _, b := range breakers {
+		b.mu.Lock()
+		b.refresh(now)
+		stat := BreakerStat{Addr: b.addr, State: b.state}
+		stat.Requests, stat.Failures = b.counts(now)
+		b.mu.Unlock()
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
@@ -0,0 +1,91 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var transitions []BreakerState
+	b := newBreaker("tcp@127.0.0.1:1", &BreakerOption{
+		MaxFailures:      2,
+		Window:           time.Second,
+		OpenTimeout:      time.Millisecond * 100,
+		HalfOpenMaxCalls: 1,
+		OnStateChange: func(addr string, from, to BreakerState) {
+			transitions = append(transitions, to)
+		},
+	})
+	failed := errors.New("failed")
+
+	t.Run("trip", func(t *testing.T) {
+		_assert(b.allow(), "closed breaker should allow")
+		b.done(failed)
+		_assert(b.allow(), "one failure shouldn't trip")
+		b.done(failed)
+		_assert(!b.allow() && b.state == StateOpen, "expect open after 2 failures")
+	})
+
+	t.Run("half-open", func(t *testing.T) {
+		time.Sleep(time.Millisecond * 150)
+		_assert(b.allow(), "expect a probe after open timeout")
+		_assert(!b.allow(), "expect only 1 probe in half-open state")
+		b.done(failed)
+		_assert(b.state == StateOpen, "failed probe should re-open")
+
+		time.Sleep(time.Millisecond * 150)
+		_assert(b.allow(), "expect a probe after open timeout")
+		b.done(context.Canceled)
+		_assert(b.state == StateHalfOpen, "canceled probe shouldn't close")
+		_assert(b.allow(), "expect another probe after the canceled one")
+		b.done(nil)
+		_assert(b.state == StateClosed, "successful probe should close")
+	})
+
+	want := []BreakerState{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}
+	_assert(fmt.Sprint(transitions) == fmt.Sprint(want), "expect transitions %v, got %v", want, transitions)
+}
+
+func TestBreaker_ErrorRate(t *testing.T) {
+	b := newBreaker("tcp@127.0.0.1:1", &BreakerOption{
+		ErrorRate:   0.5,
+		MinRequests: 4,
+		Window:      time.Second,
+		OpenTimeout: time.Second,
+	})
+	failed := errors.New("failed")
+	for _, err := range []error{nil, failed, nil} {
+		b.allow()
+		b.done(err)
+	}
+	_assert(b.state == StateClosed, "not enough requests to check error rate")
+	b.allow()
+	b.done(failed)
+	_assert(b.state == StateOpen, "expect open when error rate reaches 0.5")
+}
+
+func TestBreakerGroup_Defaults(t *testing.T) {
+	opt := &BreakerOption{MaxFailures: 1, OpenTimeout: time.Millisecond * 50}
+	g := newBreakerGroup(opt)
+	_assert(opt.HalfOpenMaxCalls == 0 && opt.Window == 0, "expect opt of the caller unchanged, got %+v", opt)
+	_assert(g.opt.Window == DefaultBreakerOption.Window && g.opt.MinRequests == DefaultBreakerOption.MinRequests,
+		"expect zero fields taken from DefaultBreakerOption, got %+v", g.opt)
+
+	b := g.get("tcp@127.0.0.1:1")
+	_assert(b.allow(), "closed breaker should allow")
+	b.done(errors.New("failed"))
+	_assert(!b.allow(), "expect open after a failure")
+	time.Sleep(time.Millisecond * 100)
+	_assert(b.allow(), "expect a probe after open timeout with default HalfOpenMaxCalls")
+	b.done(nil)
+	_assert(b.state == StateClosed, "successful probe should close")
+}
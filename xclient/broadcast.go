@@ -0,0 +1,131 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// BroadcastResult is the result of the call to a server made by BroadcastAll.
+type BroadcastResult struct {
+	Reply   interface{} // a new value of the same type as reply, nil if reply is nil
+	Err     error
+	Latency time.Duration
+}
+
+// Reducer merges src, the reply of a server, into dst, the reply given to BroadcastAll.
+// Replies are merged one at a time in the order they arrive.
+type Reducer func(dst, src interface{}) error
+
+// BroadcastOption configures BroadcastAll.
+type BroadcastOption struct {
+	Quorum int // succeed when Quorum servers reply without error, 0 means all servers
+	// ReturnOnQuorum returns as soon as the quorum is reached or can't be reached anymore,
+	// and cancels unfinished calls without waiting for them, their servers have no result.
+	// Otherwise BroadcastAll waits for every server.
+	ReturnOnQuorum bool
+	// Reduce merges every successful reply into reply, nil means reply is set to the first successful one.
+	Reduce Reducer
+}
+
+// BroadcastAll invokes the named function for every server registered in discovery
+// and returns the result of every server keyed by address.
+//
+// Unlike Broadcast, a failed call doesn't cancel the others, so every server has a result
+// unless opt.ReturnOnQuorum is set.
+// It returns an error only when fewer than opt.Quorum servers succeed. A nil opt means
+// all servers must succeed.
+func (xc *XClient) BroadcastAll(ctx context.Context, serviceMethod string, args, reply interface{},
+	opt *BroadcastOption) (map[string]*BroadcastResult, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if opt == nil {
+		opt = &BroadcastOption{}
+	}
+	quorum := opt.Quorum
+	if quorum <= 0 || quorum > len(servers) {
+		quorum = len(servers)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex // protect following
+	var reduceErr error
+	results := make(map[string]*BroadcastResult, len(servers))
+	succeeded, failed := 0, 0
+	replyDone := reply == nil      // if reply is nil, don't need to set value.
+	returned := false              // results and reply aren't touched anymore once returned
+	decided := make(chan struct{}) // closed once the quorum is reached or can't be reached anymore
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			var cloneReply interface{}
+			if reply != nil {
+				cloneReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			start := time.Now()
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, cloneReply)
+			result := &BroadcastResult{Reply: cloneReply, Err: err, Latency: time.Since(start)}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if returned {
+				return
+			}
+			results[rpcAddr] = result
+			if err != nil {
+				failed++
+				if len(servers)-failed == quorum-1 {
+					close(decided) // quorum can't be reached anymore
+				}
+				return
+			}
+			succeeded++
+			switch {
+			case opt.Reduce != nil && reply != nil:
+				if err := opt.Reduce(reply, cloneReply); err != nil && reduceErr == nil {
+					reduceErr = err
+				}
+			case !replyDone:
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(cloneReply).Elem())
+				replyDone = true
+			}
+			if succeeded == quorum {
+				close(decided)
+			}
+		}(rpcAddr)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if opt.ReturnOnQuorum {
+		select {
+		case <-decided:
+		case <-done:
+		}
+	} else {
+		<-done
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	returned = true
+	if reduceErr != nil {
+		return results, reduceErr
+	}
+	if succeeded < quorum {
+		return results, fmt.Errorf("rpc xclient: broadcast quorum not reached: %d of %d servers succeeded, need %d",
+			succeeded, len(servers), quorum)
+	}
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"testing"
+	"time"
+)
+
+type Node int
+
+func (n Node) ID(args int, reply *[]int) error {
+	if n < 0 {
+		return errors.New("bad node")
+	}
+	*reply = []int{int(n)}
+	return nil
+}
+
+func TestXClient_BroadcastAll(t *testing.T) {
+	var servers []string
+	for _, id := range []Node{1, 2, -1} {
+		server, addr := startServer()
+		_ = server.Register(id)
+		servers = append(servers, addr)
+	}
+	xc := NewXClient(NewMultiServiceDiscovery(servers), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	t.Run("all", func(t *testing.T) {
+		var reply []int
+		results, err := xc.BroadcastAll(context.Background(), "Node.ID", 0, &reply, nil)
+		_assert(err != nil && len(results) == 3, "expect an error and 3 results, got %v", err)
+		// the failure doesn't cancel the others
+		_assert(results[servers[2]].Err != nil, "expect the error of the bad node")
+		_assert(results[servers[0]].Err == nil && (*results[servers[0]].Reply.(*[]int))[0] == 1, "expect reply of node 1, got %v", results[servers[0]].Err)
+		_assert(results[servers[1]].Err == nil && (*results[servers[1]].Reply.(*[]int))[0] == 2, "expect reply of node 2, got %v", results[servers[1]].Err)
+	})
+
+	t.Run("quorum", func(t *testing.T) {
+		var reply []int
+		results, err := xc.BroadcastAll(context.Background(), "Node.ID", 0, &reply, &BroadcastOption{Quorum: 2})
+		_assert(err == nil && len(results) == 3, "expect quorum reached and 3 results, got %v", err)
+		_assert(results[servers[2]].Err != nil && results[servers[0]].Err == nil, "expect per server errors")
+		_assert((*results[servers[1]].Reply.(*[]int))[0] == 2, "expect per server reply")
+	})
+
+	t.Run("quorum with reducer", func(t *testing.T) {
+		var reply []int
+		_, err := xc.BroadcastAll(context.Background(), "Node.ID", 0, &reply, &BroadcastOption{
+			Quorum: 2,
+			Reduce: func(dst, src interface{}) error {
+				*dst.(*[]int) = append(*dst.(*[]int), *src.(*[]int)...)
+				return nil
+			},
+		})
+		_assert(err == nil && len(reply) == 2 && reply[0]+reply[1] == 3, "expect replies merged, got %v %v", reply, err)
+	})
+}
+
+func TestXClient_BroadcastAllReturnOnQuorum(t *testing.T) {
+	var servers []string
+	for _, id := range []Node{1, 2} {
+		server, addr := startServer()
+		_ = server.Register(id)
+		servers = append(servers, addr)
+	}
+	// a black hole: connections are queued but never accepted, so the HTTP CONNECT never gets answered
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	blackHole := "http@" + l.Addr().String()
+	servers = append(servers, blackHole)
+
+	xc := NewXClient(NewMultiServiceDiscovery(servers), RandomSelect, &geerpc.Option{ConnectTimeout: time.Second * 5})
+	defer func() { _ = xc.Close() }()
+
+	var reply []int
+	start := time.Now()
+	results, err := xc.BroadcastAll(context.Background(), "Node.ID", 0, &reply, &BroadcastOption{Quorum: 2, ReturnOnQuorum: true})
+	_assert(time.Since(start) < time.Second, "expect return without waiting for the black hole, took %s", time.Since(start))
+	_assert(err == nil && len(results) == 2, "expect quorum reached with 2 results, got %d %v", len(results), err)
+	_assert(results[blackHole] == nil, "expect no result of the black hole")
+}
@@ -0,0 +1,102 @@
+package xclient
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+const debugText = `<html>
+	<body>
+	<title>GeeRPC XClient</title>
+	<hr>
+	Circuit Breakers
+	<hr>
+		<table>
+		<th align=center>Server</th><th align=center>State</th><th align=center>Requests</th><th align=center>Failures</th>
+		{{range .Breakers}}
+			<tr>
+			<td align=left font=fixed>{{.Addr}}</td>
+			<td align=center>{{.State}}</td>
+			<td align=center>{{.Requests}}</td>
+			<td align=center>{{.Failures}}</td>
+			</tr>
+		{{end}}
+		</table>
+	<hr>
+	Health
+	<hr>
+		<table>
+		<th align=center>Server</th><th align=center>Healthy</th><th align=center>Last Check</th><th align=center>Last Error</th>
+		{{range .Health}}
+			<tr>
+			<td align=left font=fixed>{{.Addr}}</td>
+			<td align=center>{{.Healthy}}</td>
+			<td align=center>{{.LastCheck.Format "15:04:05"}}</td>
+			<td align=left>{{.LastErr}}</td>
+			</tr>
+		{{end}}
+		</table>
+	<hr>
+	Outliers
+	<hr>
+		<table>
+		<th align=center>Server</th><th align=center>Ejected</th><th align=center>Until</th><th align=center>Times</th><th align=center>Reason</th>
+		{{range .Ejections}}
+			<tr>
+			<td align=left font=fixed>{{.Addr}}</td>
+			<td align=center>{{.Ejected}}</td>
+			<td align=center>{{.Until.Format "15:04:05"}}</td>
+			<td align=center>{{.Times}}</td>
+			<td align=center>{{.Reason}}</td>
+			</tr>
+		{{end}}
+		</table>
+	<hr>
+	Load
+	<hr>
+		<table>
+		<th align=center>Server</th><th align=center>In-flight</th><th align=center>Latency</th>
+		{{range .Loads}}
+			<tr>
+			<td align=left font=fixed>{{.Addr}}</td>
+			<td align=center>{{.Inflight}}</td>
+			<td align=center>{{.Latency}}</td>
+			</tr>
+		{{end}}
+		</table>
+	</body>
+	</html>`
+
+var debug = template.Must(template.New("XClient debug").Parse(debugText))
+
+type debugHTTP struct {
+	*XClient
+}
+
+type debugXClient struct {
+	Breakers  []BreakerStat
+	Health    []HealthStat
+	Ejections []EjectionStat
+	Loads     []LoadStat
+}
+
+// Runs at the path given to XClient.HandleHTTP
+func (xc debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := debug.Execute(w, debugXClient{
+		Breakers:  xc.Breakers(),
+		Health:    xc.Health(),
+		Ejections: xc.Ejections(),
+		Loads:     xc.Loads(),
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
+	}
+}
+
+// HandleHTTP registers an HTTP handler showing the state of xc on debugPath.
+func (xc *XClient) HandleHTTP(debugPath string) {
+	http.Handle(debugPath, debugHTTP{xc})
+	log.Println("rpc xclient debug path:", debugPath)
+}
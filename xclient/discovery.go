@@ -2,17 +2,20 @@ package xclient
 
 import (
 	"errors"
-	"math"
-	"math/rand"
+	"fmt"
 	"sync"
-	"time"
 )
 
 type SelectMode int
 
 const (
-	RandomSelect     SelectMode = iota // select randomly
-	RoundRobinSelect                   // select using Robin algorithm
+	RandomSelect             SelectMode = iota // select randomly
+	RoundRobinSelect                           // select using Robin algorithm
+	WeightedRoundRobinSelect                   // select using smooth weighted Robin algorithm
+	WeightedRandomSelect                       // select randomly in proportion to weights
+	ConsistentHashSelect                       // select by routing key on a consistent hash ring
+	LeastActiveSelect                          // select the server with the fewest in-flight calls
+	P2CSelect                                  // select the less loaded of two random servers
 )
 
 type Discovery interface {
@@ -22,25 +25,57 @@ type Discovery interface {
 	GetAll() ([]string, error)
 }
 
+// WeightedDiscovery is implemented by discoveries which know the weight of servers,
+// XClient passes the weights to Selector.
+type WeightedDiscovery interface {
+	Weights() map[string]int
+}
+
+// LabeledDiscovery is implemented by discoveries which know the labels of servers,
+// XClient routes calls by labels, see RouteOption.
+type LabeledDiscovery interface {
+	Labels() map[string]map[string]string
+}
+
+// DiscoveryEvent reports the servers added to and removed from a discovery by a change.
+type DiscoveryEvent struct {
+	Added   []string
+	Removed []string
+}
+
+// SubscribableDiscovery is implemented by discoveries which notify changes of servers,
+// XClient drains connections to removed servers and may pre-warm connections to added ones.
+type SubscribableDiscovery interface {
+	// Subscribe calls f with the current servers as added, then with every change of servers,
+	// until unsubscribe is called. Events are delivered in order on a goroutine of the subscriber.
+	Subscribe(f func(event DiscoveryEvent)) (unsubscribe func())
+}
+
 // MultiServiceDiscovery is a discovery for multi servers without a registry center.
 // user provides the server addresses explicitly instead.
 type MultiServiceDiscovery struct {
-	r       *rand.Rand   // generate random number
-	mu      sync.RWMutex // protect following
-	servers []string
-	index   int // record the selected position for robin algorithm
+	mu          sync.RWMutex // protect following
+	servers     []string
+	weights     map[string]int               // weight of servers for weighted modes, defaultWeight if absent
+	labels      map[string]map[string]string // labels of servers for routing
+	selectors   map[SelectMode]Selector      // selectors used by Get, created lazily
+	subscribers map[*subscriber]struct{}
 }
 
 func NewMultiServiceDiscovery(servers []string) *MultiServiceDiscovery {
-	d := &MultiServiceDiscovery{
-		servers: servers,
-		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	return &MultiServiceDiscovery{
+		servers:     servers,
+		weights:     make(map[string]int),
+		labels:      make(map[string]map[string]string),
+		selectors:   make(map[SelectMode]Selector),
+		subscribers: make(map[*subscriber]struct{}),
 	}
-	d.index = d.r.Intn(math.MaxInt32 - 1)
-	return d
 }
 
 var _ Discovery = (*MultiServiceDiscovery)(nil)
+var _ WeightedDiscovery = (*MultiServiceDiscovery)(nil)
+var _ LabeledDiscovery = (*MultiServiceDiscovery)(nil)
+var _ SubscribableDiscovery = (*MultiServiceDiscovery)(nil)
 
 // Refresh does't make sense for MultiServiceDiscovery, so ignore it.
 func (d *MultiServiceDiscovery) Refresh() error {
@@ -51,11 +86,177 @@ func (d *MultiServiceDiscovery) Refresh() error {
 func (d *MultiServiceDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	d.setServers(servers)
+	return nil
+}
+
+// setServers replaces servers and notifies subscribers of the change, d.mu must be held.
+func (d *MultiServiceDiscovery) setServers(servers []string) {
+	old := d.servers
 	d.servers = servers
+	if len(d.subscribers) == 0 {
+		return
+	}
+
+	var event DiscoveryEvent
+	known := make(map[string]bool, len(old))
+	for _, server := range old {
+		known[server] = true
+	}
+	for _, server := range servers {
+		if !known[server] {
+			event.Added = append(event.Added, server)
+		}
+		delete(known, server)
+	}
+	for _, server := range old {
+		if known[server] {
+			event.Removed = append(event.Removed, server)
+			delete(known, server) // listed twice
+		}
+	}
+	if len(event.Added) == 0 && len(event.Removed) == 0 {
+		return
+	}
+	for s := range d.subscribers {
+		s.notify(event)
+	}
+}
+
+// Subscribe calls f with the current servers as added, then with every change of servers.
+func (d *MultiServiceDiscovery) Subscribe(f func(event DiscoveryEvent)) (unsubscribe func()) {
+	s := &subscriber{f: f, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go s.run()
+
+	d.mu.Lock()
+	d.subscribers[s] = struct{}{}
+	if len(d.servers) > 0 {
+		s.notify(DiscoveryEvent{Added: append([]string(nil), d.servers...)})
+	}
+	d.mu.Unlock()
+
+	return func() {
+		d.mu.Lock()
+		delete(d.subscribers, s)
+		d.mu.Unlock()
+		s.once.Do(func() { close(s.done) })
+	}
+}
+
+// subscriber calls f with events in order on its own goroutine,
+// so a slow f never blocks changes of servers.
+type subscriber struct {
+	f       func(event DiscoveryEvent)
+	mu      sync.Mutex // protect following
+	pending []DiscoveryEvent
+	wake    chan struct{}
+	done    chan struct{}
+	once    sync.Once // close done only once
+}
+
+func (s *subscriber) notify(event DiscoveryEvent) {
+	s.mu.Lock()
+	s.pending = append(s.pending, event)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default: // already woken up
+	}
+}
+
+func (s *subscriber) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+		s.mu.Lock()
+		events := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+		for _, event := range events {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.f(event)
+		}
+	}
+}
+
+// UpdateWeights sets the weights of servers used by weighted modes, servers absent
+// from weights keep their previous weight. A weight of 0 means never selected by weighted modes.
+//
+// The selection state is kept, so weights can be changed at runtime smoothly.
+func (d *MultiServiceDiscovery) UpdateWeights(weights map[string]int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.updateWeights(weights)
+}
+
+func (d *MultiServiceDiscovery) updateWeights(weights map[string]int) error {
+	for server, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("rpc discovery: negative weight %d of %s", weight, server)
+		}
+	}
+	for server, weight := range weights {
+		d.weights[server] = weight
+	}
 	return nil
 }
 
-// Get a server according to mode.
+// Weights returns the weight of every server.
+func (d *MultiServiceDiscovery) Weights() map[string]int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.serverWeights()
+}
+
+func (d *MultiServiceDiscovery) serverWeights() map[string]int {
+	weights := make(map[string]int, len(d.servers))
+	for _, server := range d.servers {
+		if weight, ok := d.weights[server]; ok {
+			weights[server] = weight
+		} else {
+			weights[server] = defaultWeight
+		}
+	}
+	return weights
+}
+
+// UpdateLabels sets the labels of servers, e.g. zone, version and canary.
+// Servers absent from labels keep their previous labels.
+func (d *MultiServiceDiscovery) UpdateLabels(labels map[string]map[string]string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.updateLabels(labels)
+	return nil
+}
+
+func (d *MultiServiceDiscovery) updateLabels(labels map[string]map[string]string) {
+	for server, l := range labels {
+		d.labels[server] = l
+	}
+}
+
+// Labels returns the labels of every server which has labels.
+func (d *MultiServiceDiscovery) Labels() map[string]map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	labels := make(map[string]map[string]string, len(d.servers))
+	for _, server := range d.servers {
+		if l, ok := d.labels[server]; ok && len(l) > 0 {
+			labels[server] = l
+		}
+	}
+	return labels
+}
+
+// Get a server according to mode, using the selector registered for mode.
 func (d *MultiServiceDiscovery) Get(mode SelectMode) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -65,16 +266,16 @@ func (d *MultiServiceDiscovery) Get(mode SelectMode) (string, error) {
 		return "", errors.New("rpc discovery: no available servers")
 	}
 
-	switch mode {
-	case RandomSelect:
-		return d.servers[d.r.Intn(n)], nil
-	case RoundRobinSelect:
-		s := d.servers[d.index%n]
-		d.index = (d.index + 1) % n
-		return s, nil
-	default:
-		return "", errors.New("rpc discovery: not supported select mode")
+	s, ok := d.selectors[mode]
+	if !ok {
+		f := NewSelectorFuncMap[mode]
+		if f == nil {
+			return "", errors.New("rpc discovery: not supported select mode")
+		}
+		s = f()
+		d.selectors[mode] = s
 	}
+	return s.Select(d.servers, &SelectInfo{Weights: d.serverWeights()})
 }
 
 func (d *MultiServiceDiscovery) GetAll() ([]string, error) {
@@ -0,0 +1,121 @@
+package xclient
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// ChildDiscovery is a source of servers of CompositeDiscovery.
+type ChildDiscovery struct {
+	Discovery Discovery
+	// Priority orders children, 0 is the highest. Servers of the highest priority
+	// which has any are used, so lower priorities are fallbacks.
+	Priority int
+}
+
+// CompositeDiscovery combines several discoveries, e.g. a registry and a static fallback list:
+//
+//	d := NewCompositeDiscovery(
+//		ChildDiscovery{Discovery: NewGeeRegistryDiscovery(registry, 0)},
+//		ChildDiscovery{Discovery: NewMultiServiceDiscovery(fallback), Priority: 1},
+//	)
+//
+// Servers of children of the same priority are merged without duplicates, their weights
+// and labels come from the first child which knows them. A child whose Refresh fails
+// is taken as having no server.
+type CompositeDiscovery struct {
+	*MultiServiceDiscovery
+	children []ChildDiscovery // sorted by priority
+}
+
+var _ Discovery = (*CompositeDiscovery)(nil)
+var _ LabeledDiscovery = (*CompositeDiscovery)(nil)
+
+func NewCompositeDiscovery(children ...ChildDiscovery) *CompositeDiscovery {
+	children = append([]ChildDiscovery(nil), children...)
+	sort.SliceStable(children, func(i, j int) bool { return children[i].Priority < children[j].Priority })
+	return &CompositeDiscovery{
+		MultiServiceDiscovery: NewMultiServiceDiscovery(make([]string, 0)),
+		children:              children,
+	}
+}
+
+// Refresh refreshes children and takes the servers of the highest priority which has any.
+// It returns an error only if no child has any server.
+func (d *CompositeDiscovery) Refresh() error {
+	var err error // last error of children
+	for i := 0; i < len(d.children); {
+		priority := d.children[i].Priority
+		var servers []string
+		weights := make(map[string]int)
+		labels := make(map[string]map[string]string)
+		for ; i < len(d.children) && d.children[i].Priority == priority; i++ {
+			child := d.children[i].Discovery
+			childErr := child.Refresh()
+			var childServers []string
+			if childErr == nil {
+				childServers, childErr = child.GetAll()
+			}
+			if childErr != nil {
+				log.Println("rpc discovery: child discovery err:", childErr)
+				err = childErr
+				continue
+			}
+			servers = mergeChild(child, childServers, servers, weights, labels)
+		}
+		if len(servers) > 0 {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.setServers(servers)
+			_ = d.updateWeights(weights)
+			d.updateLabels(labels)
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("rpc discovery: no available servers, last err: %w", err)
+	}
+	return errors.New("rpc discovery: no available servers")
+}
+
+// mergeChild appends servers of child absent from servers, with their weights and labels.
+func mergeChild(child Discovery, childServers, servers []string,
+	weights map[string]int, labels map[string]map[string]string) []string {
+	var childWeights map[string]int
+	if wd, ok := child.(WeightedDiscovery); ok {
+		childWeights = wd.Weights()
+	}
+	var childLabels map[string]map[string]string
+	if ld, ok := child.(LabeledDiscovery); ok {
+		childLabels = ld.Labels()
+	}
+	for _, server := range childServers {
+		if _, ok := weights[server]; ok {
+			continue // known by a child before
+		}
+		weight, ok := childWeights[server]
+		if !ok {
+			weight = defaultWeight
+		}
+		weights[server] = weight
+		labels[server] = childLabels[server]
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func (d *CompositeDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServiceDiscovery.Get(mode)
+}
+
+func (d *CompositeDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServiceDiscovery.GetAll()
+}
@@ -0,0 +1,75 @@
+package xclient
+
+import (
+	"geerpc/registry"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompositeDiscovery(t *testing.T) {
+	r := registry.New(time.Minute)
+	defer func() { _ = r.Close() }()
+	ts := httptest.NewServer(r)
+	h := registry.NewHeartbeater(ts.URL, registry.ServerItem{Addr: "tcp@a", Weight: 5}, nil)
+	_ = h.Start()
+
+	static1 := NewMultiServiceDiscovery([]string{"tcp@b", "tcp@c"})
+	_ = static1.UpdateWeights(map[string]int{"tcp@c": 2})
+	static2 := NewMultiServiceDiscovery([]string{"tcp@c", "tcp@d"})
+	_ = static2.UpdateWeights(map[string]int{"tcp@c": 3})
+	d := NewCompositeDiscovery(
+		ChildDiscovery{Discovery: static1, Priority: 1},
+		ChildDiscovery{Discovery: NewGeeRegistryDiscovery(ts.URL, time.Millisecond*10)},
+		ChildDiscovery{Discovery: static2, Priority: 1},
+	)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1 && servers[0] == "tcp@a", "expect servers of the registry, got %v %v", servers, err)
+	_assert(d.Weights()["tcp@a"] == 5, "expect weight from the registry, got %v", d.Weights())
+
+	// the registry is down, fall back to static lists
+	_ = h.Stop()
+	ts.Close()
+	time.Sleep(time.Millisecond * 20)
+	servers, err = d.GetAll()
+	_assert(err == nil && len(servers) == 3, "expect merged static servers, got %v %v", servers, err)
+	_assert(d.Weights()["tcp@c"] == 2, "expect weight from the first child, got %v", d.Weights())
+
+	d = NewCompositeDiscovery(ChildDiscovery{Discovery: NewMultiServiceDiscovery(nil)})
+	_, err = d.GetAll()
+	_assert(err != nil, "expect error without any server")
+}
+
+func TestGeeRegistryDiscoverySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	r := registry.New(time.Minute)
+	defer func() { _ = r.Close() }()
+	ts := httptest.NewServer(r)
+	h := registry.NewHeartbeater(ts.URL, registry.ServerItem{Addr: "tcp@a"}, nil)
+	_ = h.Start()
+
+	d := NewGeeRegistryDiscovery(ts.URL, time.Millisecond*10)
+	d.SetSnapshot(path)
+	servers, _ := d.GetAll()
+	_assert(len(servers) == 1, "expect tcp@a, got %v", servers)
+	_, err := os.Stat(path)
+	_assert(err == nil, "expect snapshot written, got %v", err)
+
+	_ = h.Stop()
+	ts.Close()
+	time.Sleep(time.Millisecond * 20)
+	servers, err = d.GetAll()
+	_assert(err == nil && len(servers) == 1, "expect servers answered before kept, got %v %v", servers, err)
+
+	// a restarted client reads the snapshot
+	d = NewGeeRegistryDiscovery(ts.URL, 0)
+	d.SetSnapshot(path)
+	servers, err = d.GetAll()
+	_assert(err == nil && len(servers) == 1 && servers[0] == "tcp@a", "expect servers of snapshot, got %v %v", servers, err)
+
+	d = NewGeeRegistryDiscovery(ts.URL, 0)
+	_, err = d.GetAll()
+	_assert(err != nil, "expect error without snapshot")
+}
@@ -0,0 +1,200 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Resolver resolves DNS names for DNSDiscovery, *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// DNSDiscovery discovers servers by DNS, from the A/AAAA records of a host,
+// or from SRV records whose targets are resolved to their A/AAAA records.
+// Servers are tcp@ip:port, they are resolved again every timeout, which stands
+// for the TTL of records since it isn't exposed by net.Resolver.
+//
+// Only the SRV records of the lowest priority are used, their weights are the weights
+// of servers, used by weighted select modes, e.g. WeightedRandomSelect. Targets failing
+// to resolve are skipped, records of the next priority are used if no target of a priority
+// resolves.
+//
+// A failed resolution keeps the servers resolved before.
+type DNSDiscovery struct {
+	*MultiServiceDiscovery
+	host       string // host resolved to A/AAAA records, "" for SRV
+	port       string
+	service    string // service, proto and name of SRV records
+	proto      string
+	name       string
+	resolver   Resolver
+	timeout    time.Duration
+	lastUpdate time.Time
+	refreshMu  sync.Mutex // only one Refresh resolves at a time, without holding mu
+}
+
+const defaultDNSTimeout = time.Second * 30
+
+var _ WeightedDiscovery = (*DNSDiscovery)(nil)
+
+// NewDNSDiscovery creates a DNSDiscovery resolving the host of hostport, servers listen on its port.
+// timeout is the time between two resolutions, 0 means defaultDNSTimeout.
+func NewDNSDiscovery(hostport string, timeout time.Duration) (*DNSDiscovery, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	d := newDNSDiscovery(timeout)
+	d.host, d.port = host, port
+	return d, nil
+}
+
+// NewDNSSRVDiscovery creates a DNSDiscovery looking up the SRV records _service._proto.name,
+// if service and proto are "", name is looked up directly, see net.LookupSRV.
+func NewDNSSRVDiscovery(service, proto, name string, timeout time.Duration) *DNSDiscovery {
+	d := newDNSDiscovery(timeout)
+	d.service, d.proto, d.name = service, proto, name
+	return d
+}
+
+func newDNSDiscovery(timeout time.Duration) *DNSDiscovery {
+	if timeout == 0 {
+		timeout = defaultDNSTimeout
+	}
+	return &DNSDiscovery{
+		MultiServiceDiscovery: NewMultiServiceDiscovery(make([]string, 0)),
+		resolver:              net.DefaultResolver,
+		timeout:               timeout,
+	}
+}
+
+// SetResolver sets the resolver, net.DefaultResolver by default.
+// It should be called before d is used.
+func (d *DNSDiscovery) SetResolver(resolver Resolver) {
+	d.resolver = resolver
+}
+
+func (d *DNSDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.setServers(servers)
+	d.lastUpdate = time.Now()
+
+	return nil
+}
+
+// Refresh resolves servers again once timeout elapsed since the last resolution.
+// An error is returned only if no server was ever resolved. Names are resolved
+// without holding d.mu, so servers resolved before stay readable meanwhile.
+func (d *DNSDiscovery) Refresh() error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+
+	d.mu.RLock()
+	fresh := d.lastUpdate.Add(d.timeout).After(time.Now())
+	d.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultUpdateTimeout)
+	defer cancel()
+	weights, err := d.resolve(ctx)
+	if err == nil && len(weights) == 0 {
+		err = errors.New("rpc discovery: no address resolved")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		if len(d.servers) == 0 {
+			return err
+		}
+		log.Println("rpc discovery: keep servers resolved before, err:", err)
+		d.lastUpdate = time.Now() // retry after timeout
+		return nil
+	}
+
+	servers := make([]string, 0, len(weights))
+	for server := range weights {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	_ = d.updateWeights(weights)
+	d.setServers(servers)
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// resolve returns the weight of every server resolved.
+func (d *DNSDiscovery) resolve(ctx context.Context) (map[string]int, error) {
+	weights := make(map[string]int)
+	if d.host != "" {
+		if err := d.resolveHost(ctx, d.host, d.port, defaultWeight, weights); err != nil {
+			return nil, err
+		}
+		return weights, nil
+	}
+
+	_, records, err := d.resolver.LookupSRV(ctx, d.service, d.proto, d.name)
+	if err != nil {
+		return nil, err
+	}
+	records = append([]*net.SRV(nil), records...)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Priority < records[j].Priority })
+	for i := 0; i < len(records); {
+		priority := records[i].Priority
+		for ; i < len(records) && records[i].Priority == priority; i++ {
+			srv := records[i]
+			// weight 0 means a very small chance to be selected, see RFC 2782
+			weight := int(srv.Weight)
+			if weight == 0 {
+				weight = 1
+			}
+			port := strconv.Itoa(int(srv.Port))
+			if hostErr := d.resolveHost(ctx, srv.Target, port, weight, weights); hostErr != nil {
+				log.Println("rpc discovery: skip srv target", srv.Target, "err:", hostErr)
+				err = hostErr
+			}
+		}
+		if len(weights) > 0 {
+			return weights, nil
+		}
+	}
+	return weights, err
+}
+
+// resolveHost adds the addresses of host with weight to weights.
+func (d *DNSDiscovery) resolveHost(ctx context.Context, host, port string, weight int, weights map[string]int) error {
+	addrs, err := d.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		weights["tcp@"+net.JoinHostPort(addr.IP.String(), port)] += weight
+	}
+	return nil
+}
+
+func (d *DNSDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServiceDiscovery.Get(mode)
+}
+
+func (d *DNSDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServiceDiscovery.GetAll()
+}
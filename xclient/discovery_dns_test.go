@@ -0,0 +1,133 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// stubResolver answers DNS lookups from its records.
+type stubResolver struct {
+	hosts map[string][]net.IPAddr
+	srvs  []*net.SRV
+	err   error
+}
+
+func (r *stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (r *stubResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return name, r.srvs, nil
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	resolver := &stubResolver{
+		hosts: map[string][]net.IPAddr{
+			"geerpc.local": {{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("fd00::1")}},
+			"a.local.":     {{IP: net.ParseIP("10.0.0.2")}},
+			"b.local.":     {{IP: net.ParseIP("10.0.0.3")}},
+			"backup.local": {{IP: net.ParseIP("10.0.0.4")}},
+		},
+		srvs: []*net.SRV{
+			{Target: "a.local.", Port: 9999, Priority: 10, Weight: 3},
+			{Target: "b.local.", Port: 9998, Priority: 10, Weight: 1},
+			{Target: "backup.local", Port: 9999, Priority: 20, Weight: 1},
+		},
+	}
+
+	t.Run("host", func(t *testing.T) {
+		d, err := NewDNSDiscovery("geerpc.local:9999", 0)
+		_assert(err == nil, "new dns discovery error: %v", err)
+		d.SetResolver(resolver)
+		servers, err := d.GetAll()
+		_assert(err == nil && len(servers) == 2, "expect 2 servers, got %v %v", servers, err)
+		_assert(servers[0] == "tcp@10.0.0.1:9999" && servers[1] == "tcp@[fd00::1]:9999", "expect tcp@ip:port, got %v", servers)
+
+		_, err = NewDNSDiscovery("geerpc.local", 0)
+		_assert(err != nil, "expect error for a missing port")
+	})
+
+	t.Run("srv", func(t *testing.T) {
+		d := NewDNSSRVDiscovery("geerpc", "tcp", "geerpc.local", time.Millisecond*10)
+		d.SetResolver(resolver)
+		servers, err := d.GetAll()
+		_assert(err == nil && len(servers) == 2, "expect servers of the lowest priority, got %v %v", servers, err)
+		weights := d.Weights()
+		_assert(weights["tcp@10.0.0.2:9999"] == 3 && weights["tcp@10.0.0.3:9998"] == 1, "expect srv weights, got %v", weights)
+
+		// a failed resolution keeps the servers resolved before
+		resolver.err = errors.New("dns down")
+		time.Sleep(time.Millisecond * 20)
+		servers, err = d.GetAll()
+		_assert(err == nil && len(servers) == 2, "expect servers kept, got %v %v", servers, err)
+		resolver.err = nil
+
+		// a target failing to resolve is skipped
+		resolver.srvs = append(resolver.srvs, &net.SRV{Target: "gone.local.", Port: 9999, Priority: 10, Weight: 1})
+		d = NewDNSSRVDiscovery("", "", "geerpc.local", 0)
+		d.SetResolver(resolver)
+		servers, err = d.GetAll()
+		_assert(err == nil && len(servers) == 2, "expect the failing target skipped, got %v %v", servers, err)
+
+		// the next priority is used when no target of the lowest one resolves
+		delete(resolver.hosts, "a.local.")
+		delete(resolver.hosts, "b.local.")
+		d = NewDNSSRVDiscovery("", "", "geerpc.local", 0)
+		d.SetResolver(resolver)
+		servers, err = d.GetAll()
+		_assert(err == nil && len(servers) == 1 && servers[0] == "tcp@10.0.0.4:9999", "expect the backup priority, got %v %v", servers, err)
+
+		delete(resolver.hosts, "backup.local")
+		d = NewDNSSRVDiscovery("", "", "geerpc.local", 0)
+		d.SetResolver(resolver)
+		_, err = d.GetAll()
+		_assert(err != nil, "expect error when nothing was resolved")
+	})
+}
+
+// blockingResolver blocks lookups until release is closed.
+type blockingResolver struct {
+	stubResolver
+	asked, release chan struct{}
+}
+
+func (r *blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	close(r.asked)
+	<-r.release
+	return r.stubResolver.LookupIPAddr(ctx, host)
+}
+
+func TestDNSDiscoveryRefreshUnlocked(t *testing.T) {
+	resolver := &blockingResolver{
+		stubResolver: stubResolver{hosts: map[string][]net.IPAddr{"geerpc.local": {{IP: net.ParseIP("10.0.0.2")}}}},
+		asked:        make(chan struct{}),
+		release:      make(chan struct{}),
+	}
+	d, _ := NewDNSDiscovery("geerpc.local:9999", time.Millisecond)
+	d.SetResolver(resolver)
+	_ = d.Update([]string{"tcp@10.0.0.1:9999"})
+	time.Sleep(time.Millisecond * 5)
+	refreshed := make(chan error)
+	go func() { refreshed <- d.Refresh() }()
+
+	<-resolver.asked
+	servers, _ := d.MultiServiceDiscovery.GetAll()
+	_assert(len(servers) == 1 && servers[0] == "tcp@10.0.0.1:9999", "expect servers readable while resolving, got %v", servers)
+	close(resolver.release)
+	_assert(<-refreshed == nil, "refresh error")
+	servers, _ = d.MultiServiceDiscovery.GetAll()
+	_assert(len(servers) == 1 && servers[0] == "tcp@10.0.0.2:9999", "expect servers resolved again, got %v", servers)
+}
@@ -0,0 +1,229 @@
+package xclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"geerpc"
+	"geerpc/registry"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileDiscovery reads servers and their metadata from a file, and reloads the file
+// when it changes, so servers can be changed without restarting, e.g. for local
+// development or static deployments.
+//
+// The file is either JSON, a list of servers like the answer of GeeRegistry:
+//
+//	{"servers": [{"addr": "tcp@10.0.0.1:9999", "weight": 2, "zone": "z1"}]}
+//
+// or has a server per line, followed by its weight and labels, # starts a comment:
+//
+//	tcp@10.0.0.1:9999 weight=2 zone=z1
+//	tcp@10.0.0.2:9999 canary=true
+//
+// Addresses must be in the protocol@addr format of geerpc.XDial. A weight must be positive,
+// a server without weight gets the default weight. A file which fails to parse is reported
+// by Err, and the servers loaded before are kept.
+type FileDiscovery struct {
+	*MultiServiceDiscovery
+	path     string
+	interval time.Duration
+	loadMu   sync.Mutex // protect following
+	modTime  time.Time  // of the file loaded or failed to load
+	size     int64
+	err      error // error of the last load, nil if it succeeded
+	done     chan struct{}
+	once     sync.Once // close done only once
+}
+
+const defaultFileInterval = time.Second * 5
+
+var _ LabeledDiscovery = (*FileDiscovery)(nil)
+
+// NewFileDiscovery loads servers from the file at path, and checks the modification time
+// of the file every interval to reload it, 0 means defaultFileInterval.
+// It returns an error if the file fails to load the first time.
+func NewFileDiscovery(path string, interval time.Duration) (*FileDiscovery, error) {
+	if interval <= 0 {
+		interval = defaultFileInterval
+	}
+	d := &FileDiscovery{
+		MultiServiceDiscovery: NewMultiServiceDiscovery(make([]string, 0)),
+		path:                  path,
+		interval:              interval,
+		done:                  make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	go d.watch()
+	return d, nil
+}
+
+// Close stops watching the file.
+func (d *FileDiscovery) Close() error {
+	d.once.Do(func() { close(d.done) })
+	return nil
+}
+
+// Refresh reloads the file at once if it changed since the last load,
+// it returns the error of the last load.
+func (d *FileDiscovery) Refresh() error {
+	d.loadMu.Lock()
+	defer d.loadMu.Unlock()
+
+	info, err := os.Stat(d.path)
+	if err != nil {
+		d.err = err
+		return err
+	}
+	if info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return d.err
+	}
+	d.modTime, d.size = info.ModTime(), info.Size()
+
+	data, err := os.ReadFile(d.path)
+	var list *registry.ServerList
+	if err == nil {
+		list, err = parseServerFile(data)
+	}
+	if err != nil {
+		err = fmt.Errorf("rpc discovery: load %s: %w", d.path, err)
+	}
+	if d.err = err; err != nil {
+		return err
+	}
+
+	servers := make([]string, 0, len(list.Servers))
+	weights := make(map[string]int)
+	labels := make(map[string]map[string]string)
+	for i := range list.Servers {
+		s := &list.Servers[i]
+		servers = append(servers, s.Addr)
+		labels[s.Addr] = s.AllLabels()
+		// 0 weight means not set
+		if s.Weight > 0 {
+			weights[s.Addr] = s.Weight
+		} else {
+			weights[s.Addr] = defaultWeight
+		}
+	}
+	_ = d.UpdateWeights(weights)
+	_ = d.UpdateLabels(labels)
+	return d.Update(servers)
+}
+
+// Err returns the error of the last load of the file, nil if it succeeded.
+func (d *FileDiscovery) Err() error {
+	d.loadMu.Lock()
+	defer d.loadMu.Unlock()
+	return d.err
+}
+
+// watch checks the file every interval until d is closed.
+func (d *FileDiscovery) watch() {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C:
+		}
+		if err := d.Refresh(); err != nil {
+			log.Println("rpc discovery: keep servers loaded before, err:", err)
+		}
+	}
+}
+
+// parseServerFile parses the JSON or line format of FileDiscovery and validates servers.
+func parseServerFile(data []byte) (*registry.ServerList, error) {
+	var list registry.ServerList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		// ServerItem can't tell a weight of 0 from no weight, check weights set explicitly
+		var weights struct {
+			Servers []struct {
+				Weight *int `json:"weight"`
+			} `json:"servers"`
+		}
+		_ = json.Unmarshal(data, &weights)
+		for i, s := range weights.Servers {
+			if s.Weight != nil && *s.Weight == 0 {
+				return nil, fmt.Errorf("zero weight of %s, omit it for the default weight", list.Servers[i].Addr)
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; scanner.Scan(); n++ {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			item, err := parseServerLine(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			list.Servers = append(list.Servers, item)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool, len(list.Servers))
+	for _, s := range list.Servers {
+		if _, _, err := geerpc.ParseRPCAddr(s.Addr); err != nil {
+			return nil, err
+		}
+		if seen[s.Addr] {
+			return nil, fmt.Errorf("duplicate server %s", s.Addr)
+		}
+		if s.Weight < 0 {
+			return nil, fmt.Errorf("negative weight %d of %s", s.Weight, s.Addr)
+		}
+		seen[s.Addr] = true
+	}
+	return &list, nil
+}
+
+// parseServerLine parses the address of a server followed by key=value pairs,
+// weight is the weight of the server, other keys are labels.
+func parseServerLine(fields []string) (registry.ServerItem, error) {
+	item := registry.ServerItem{Addr: fields[0]}
+	for _, field := range fields[1:] {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || k == "" {
+			return item, fmt.Errorf("malformed %q of %s, expect key=value", field, item.Addr)
+		}
+		if k == "weight" {
+			weight, err := strconv.Atoi(v)
+			if err != nil {
+				return item, fmt.Errorf("malformed weight %q of %s", v, item.Addr)
+			}
+			if weight == 0 {
+				return item, fmt.Errorf("zero weight of %s, omit it for the default weight", item.Addr)
+			}
+			item.Weight = weight
+			continue
+		}
+		if item.Labels == nil {
+			item.Labels = make(map[string]string)
+		}
+		item.Labels[k] = v
+	}
+	return item, nil
+}
@@ -0,0 +1,47 @@
+package xclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers")
+	_ = os.WriteFile(path, []byte(`{"servers": [{"addr": "tcp@a", "weight": 2, "zone": "z1"}]}`), 0644)
+	d, err := NewFileDiscovery(path, time.Millisecond*10)
+	_assert(err == nil, "new file discovery error: %v", err)
+	defer func() { _ = d.Close() }()
+	servers, _ := d.GetAll()
+	_assert(len(servers) == 1 && servers[0] == "tcp@a", "expect tcp@a loaded, got %v", servers)
+	_assert(d.Weights()["tcp@a"] == 2 && d.Labels()["tcp@a"][LabelZone] == "z1", "expect metadata of tcp@a loaded")
+
+	t.Run("reload", func(t *testing.T) {
+		_ = os.WriteFile(path, []byte("# servers\ntcp@a weight=3\ntcp@b canary=true # new\n"), 0644)
+		servers = waitServers(d, 2, time.Second)
+		_assert(len(servers) == 2, "expect file reloaded, got %v", servers)
+		_assert(d.Weights()["tcp@a"] == 3 && d.Labels()["tcp@b"][LabelCanary] == "true", "expect metadata reloaded")
+	})
+
+	t.Run("bad file", func(t *testing.T) {
+		_ = os.WriteFile(path, []byte("tcp@a\n10.0.0.1:9999\n"), 0644)
+		deadline := time.Now().Add(time.Second)
+		for d.Err() == nil && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+		}
+		_assert(d.Err() != nil, "expect parse error reported")
+		servers, _ = d.GetAll()
+		_assert(len(servers) == 2, "expect last good servers kept, got %v", servers)
+	})
+
+	for _, data := range []string{"tcp@a weight=0\n", `{"servers": [{"addr": "tcp@a", "weight": 0}]}`} {
+		_, err := parseServerFile([]byte(data))
+		_assert(err != nil, "expect error for zero weight in %q", data)
+	}
+	list, err := parseServerFile([]byte(`{"servers": [{"addr": "tcp@a"}]}`))
+	_assert(err == nil && list.Servers[0].Weight == 0, "expect missing weight accepted, got %v", err)
+
+	_, err = NewFileDiscovery(filepath.Join(t.TempDir(), "missing"), 0)
+	_assert(err != nil, "expect error for a missing file")
+}
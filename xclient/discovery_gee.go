@@ -1,70 +1,328 @@
 package xclient
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"geerpc"
+	"geerpc/registry"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// GeeRegistryDiscovery polls servers from a GeeRegistry every timeout. Given the URLs of
+// a registry cluster, it fails over to the next registry when the one in use fails.
+//
+// It may be scoped to a namespace and a service, so it only returns servers of the namespace
+// which report serving the service.
+//
+// A registry given as a geerpc address (protocol@addr) instead of a URL is asked
+// through its Registry service, see registry.GeeRegistry.Service.
 type GeeRegistryDiscovery struct {
 	*MultiServiceDiscovery
-	registry   string
+	registries []string
+	current    int    // index of the registry in use
+	namespace  string // "" means all namespaces
+	service    string // "" means all servers
 	timeout    time.Duration
 	lastUpdate time.Time
+	revision   uint64 // revision of servers answered by the registry, 0 if unknown
+	// snapshot is the file keeping the last servers answered, "" if disabled, see SetSnapshot.
+	snapshot     string
+	snapshotData []byte     // content of the snapshot, protected by mu
+	refreshMu    sync.Mutex // only one Refresh asks registries at a time, without holding mu
+	rpcMu        sync.Mutex // protect following
+	rpcOpt       *geerpc.Option
+	rpcs         map[string]*registry.Client // clients of registries given as geerpc addresses
 }
 
 const defaultUpdateTimeout = time.Second * 10
 
 func NewGeeRegistryDiscovery(registerAddr string, timeout time.Duration) *GeeRegistryDiscovery {
+	return NewGeeRegistryClusterDiscovery([]string{registerAddr}, timeout)
+}
+
+// NewGeeRegistryClusterDiscovery creates a GeeRegistryDiscovery using the registries
+// of a cluster in order, the first is used until it fails.
+func NewGeeRegistryClusterDiscovery(registries []string, timeout time.Duration) *GeeRegistryDiscovery {
+	return NewGeeRegistryServiceDiscovery(registries, "", "", timeout)
+}
+
+// NewGeeRegistryServiceDiscovery is like NewGeeRegistryClusterDiscovery, but only returns servers
+// of namespace which serve service, "" means any namespace or any service.
+// It panics if registries is empty.
+func NewGeeRegistryServiceDiscovery(registries []string, namespace, service string, timeout time.Duration) *GeeRegistryDiscovery {
+	if len(registries) == 0 {
+		panic("rpc discovery: no registry given")
+	}
 	if timeout == 0 {
 		timeout = defaultUpdateTimeout
 	}
 
 	d := &GeeRegistryDiscovery{
 		MultiServiceDiscovery: NewMultiServiceDiscovery(make([]string, 0)),
-		registry:              registerAddr,
+		registries:            append([]string(nil), registries...),
+		namespace:             namespace,
+		service:               service,
 		timeout:               timeout,
 	}
-
 	return d
 }
 
+// SetOption sets the option dialing registries given as geerpc addresses, nil means
+// geerpc.DefaultOption. It should be called before d is used.
+func (d *GeeRegistryDiscovery) SetOption(opt *geerpc.Option) {
+	_ = d.Close()
+	d.rpcMu.Lock()
+	d.rpcOpt = opt
+	d.rpcMu.Unlock()
+}
+
+// Close closes connections to registries given as geerpc addresses.
+func (d *GeeRegistryDiscovery) Close() error {
+	d.rpcMu.Lock()
+	defer d.rpcMu.Unlock()
+
+	for addr, c := range d.rpcs {
+		_ = c.Close()
+		delete(d.rpcs, addr)
+	}
+	return nil
+}
+
+// rpcClient returns the client of the Registry service at addr.
+func (d *GeeRegistryDiscovery) rpcClient(addr string) *registry.Client {
+	d.rpcMu.Lock()
+	defer d.rpcMu.Unlock()
+
+	if d.rpcs == nil {
+		d.rpcs = make(map[string]*registry.Client)
+	}
+	c, ok := d.rpcs[addr]
+	if !ok {
+		c = registry.NewClient(addr, d.rpcOpt)
+		d.rpcs[addr] = c
+	}
+	return c
+}
+
+// registry returns the URL of the registry in use, d.mu must be held.
+func (d *GeeRegistryDiscovery) registry() string {
+	return d.registries[d.current]
+}
+
+// fetch asks registryAddr for servers in scope. With watch, the registry answers once
+// the revision of servers differs from revision, or its watch timeout elapses.
+func (d *GeeRegistryDiscovery) fetch(ctx context.Context, client *http.Client, registryAddr string,
+	watch bool, revision uint64) (*registry.ServerList, error) {
+	if registry.IsRPCAddr(registryAddr) {
+		c := d.rpcClient(registryAddr)
+		args := registry.ListArgs{Namespace: d.namespace, Service: d.service}
+		if watch {
+			// like the HTTP client of GeeRegistryWatchDiscovery, give up if the registry holds the watch too long
+			ctx, cancel := context.WithTimeout(ctx, defaultWatchTimeout+defaultUpdateTimeout)
+			defer cancel()
+			return c.Watch(ctx, registry.WatchArgs{ListArgs: args, Revision: revision, Timeout: defaultWatchTimeout})
+		}
+		return c.List(ctx, args)
+	}
+
+	var query url.Values
+	if watch {
+		query = url.Values{
+			"watch":    {"1"},
+			"revision": {strconv.FormatUint(revision, 10)},
+			"timeout":  {defaultWatchTimeout.String()},
+		}
+	}
+	rawURL, err := d.lookupURL(registryAddr, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fetchServers(client, req)
+}
+
+// lookupURL returns registryURL with query scoping servers.
+func (d *GeeRegistryDiscovery) lookupURL(registryURL string, query url.Values) (string, error) {
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if d.namespace != "" {
+		q.Set("namespace", d.namespace)
+	}
+	if d.service != "" {
+		q.Set("service", d.service)
+	}
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// failover switches to the next registry, d.mu must be held.
+func (d *GeeRegistryDiscovery) failover() {
+	d.current = (d.current + 1) % len(d.registries)
+}
+
 func (d *GeeRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	d.servers = servers
+	d.setServers(servers)
 	d.lastUpdate = time.Now()
 
 	return nil
 }
 
+// Refresh asks the registries in order for servers once timeout elapsed since the last update.
+// Registries are asked without holding d.mu, so servers known so far stay readable meanwhile.
 func (d *GeeRegistryDiscovery) Refresh() error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
 
-	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+	d.mu.RLock()
+	fresh := d.lastUpdate.Add(d.timeout).After(time.Now())
+	d.mu.RUnlock()
+	if fresh {
 		return nil
 	}
 
-	log.Println("rpc registry: refresh servers from registry", d.registry)
-	resp, err := http.Get(d.registry)
-	if err != nil {
+	var err error
+	for range d.registries {
+		d.mu.RLock()
+		registryAddr := d.registry()
+		d.mu.RUnlock()
+		log.Println("rpc registry: refresh servers from registry", registryAddr)
+		var list *registry.ServerList
+		ctx, cancel := context.WithTimeout(context.Background(), defaultUpdateTimeout)
+		list, err = d.fetch(ctx, http.DefaultClient, registryAddr, false, 0)
+		cancel()
+		if err == nil {
+			d.mu.Lock()
+			d.update(list)
+			d.mu.Unlock()
+			return nil
+		}
 		log.Println("rpc registry refresh err:", err)
-		return err
+		d.mu.Lock()
+		d.failover()
+		d.mu.Unlock()
 	}
 
-	servers := strings.Split(resp.Header.Get("X-Geerpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.snapshot != "" && d.fallback() {
+		return nil
+	}
+	return err
+}
+
+// update sets servers, their weights and labels from the list answered by the registry,
+// unhealthy servers are skipped, d.mu must be held.
+func (d *GeeRegistryDiscovery) update(list *registry.ServerList) {
+	servers := make([]string, 0, len(list.Servers))
+	serverWeights := make(map[string]int)
+	serverLabels := make(map[string]map[string]string)
+	for i := range list.Servers {
+		s := &list.Servers[i]
+		if s.Unhealthy {
+			continue // failing the probes of the registry
+		}
+		servers = append(servers, s.Addr)
+		serverLabels[s.Addr] = s.AllLabels()
+		// 0 weight means not set
+		if s.Weight > 0 {
+			serverWeights[s.Addr] = s.Weight
+		} else {
+			serverWeights[s.Addr] = defaultWeight
 		}
 	}
+	_ = d.updateWeights(serverWeights)
+	d.updateLabels(serverLabels)
+	d.setServers(servers)
 	d.lastUpdate = time.Now()
+	if list.Revision != 0 {
+		d.revision = list.Revision
+	}
+	if d.snapshot != "" {
+		d.saveSnapshot(list)
+	}
+}
 
-	return nil
+// fetchServers sends req to the registry asking for JSON, old registries answer in headers.
+func fetchServers(client *http.Client, req *http.Request) (*registry.ServerList, error) {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc registry: status %s", resp.Status)
+	}
+	if t, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); t == "application/json" {
+		var list registry.ServerList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return nil, err
+		}
+		return &list, nil
+	}
+	return readHeader(resp.Header), nil
+}
+
+// readHeader reads servers answered by an old registry in headers.
+func readHeader(header http.Header) *registry.ServerList {
+	list := new(registry.ServerList)
+	// revision is missing for registries which don't support watch
+	list.Revision, _ = strconv.ParseUint(header.Get("X-Geerpc-Revision"), 10, 64)
+	servers := strings.Split(header.Get("X-Geerpc-Servers"), ",")
+	// weights and labels are in the same order as servers, missing for old registries
+	weights := strings.Split(header.Get("X-Geerpc-Weights"), ",")
+	labels := strings.Split(header.Get("X-Geerpc-Labels"), ",")
+	for i, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		item := registry.ServerItem{Addr: server}
+		if i < len(weights) {
+			// malformed weight means not set
+			if weight, err := strconv.Atoi(strings.TrimSpace(weights[i])); err == nil && weight > 0 {
+				item.Weight = weight
+			}
+		}
+		if i < len(labels) {
+			item.Labels = parseLabels(labels[i])
+		}
+		list.Servers = append(list.Servers, item)
+	}
+	return list
+}
+
+// parseLabels decodes url-encoded labels, malformed labels are ignored.
+func parseLabels(s string) map[string]string {
+	values, err := url.ParseQuery(strings.TrimSpace(s))
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(values))
+	for k := range values {
+		labels[k] = values.Get(k)
+	}
+	return labels
 }
 
 func (d *GeeRegistryDiscovery) Get(mode SelectMode) (string, error) {
@@ -0,0 +1,74 @@
+package xclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"geerpc/registry"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SetSnapshot keeps the last servers answered by a registry in the file at path.
+// When no registry answers, Refresh falls back to the servers answered before,
+// or to the snapshot if none was answered since d was created, e.g. the client
+// restarted while registries are down. It should be called before d is used.
+func (d *GeeRegistryDiscovery) SetSnapshot(path string) {
+	d.snapshot = path
+}
+
+// fallback keeps the servers answered before, or loads them from the snapshot,
+// it reports whether d has servers to use, d.mu must be held.
+func (d *GeeRegistryDiscovery) fallback() bool {
+	if !d.lastUpdate.IsZero() {
+		log.Println("rpc registry: no registry answers, keep servers answered before")
+		d.lastUpdate = time.Now() // retry after timeout
+		return true
+	}
+	data, err := os.ReadFile(d.snapshot)
+	if err != nil {
+		log.Println("rpc registry: read snapshot err:", err)
+		return false
+	}
+	var list registry.ServerList
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Println("rpc registry: read snapshot err:", err)
+		return false
+	}
+	log.Println("rpc registry: no registry answers, use servers of snapshot", d.snapshot)
+	d.snapshotData = data
+	d.update(&list)
+	return true
+}
+
+// saveSnapshot writes list to the snapshot if it changed, d.mu must be held.
+// It's written to a temporary file renamed over the snapshot, so a crash
+// never leaves a partial snapshot.
+func (d *GeeRegistryDiscovery) saveSnapshot(list *registry.ServerList) {
+	data, err := json.Marshal(list)
+	if err != nil || bytes.Equal(data, d.snapshotData) {
+		return
+	}
+	if err := writeFileAtomic(d.snapshot, data); err != nil {
+		log.Println("rpc registry: write snapshot err:", err)
+		return
+	}
+	d.snapshotData = data
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // fails once renamed
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
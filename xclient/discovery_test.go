@@ -0,0 +1,162 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMultiServiceDiscovery_Weighted(t *testing.T) {
+	d := NewMultiServiceDiscovery([]string{"a", "b", "c"})
+	_ = d.UpdateWeights(map[string]int{"a": 5, "b": 1, "c": 1})
+
+	t.Run("smooth weighted round robin", func(t *testing.T) {
+		var picks string
+		for i := 0; i < 7; i++ {
+			s, _ := d.Get(WeightedRoundRobinSelect)
+			picks += s
+		}
+		_assert(picks == "aabacaa", "expect smooth sequence aabacaa, got %s", picks)
+	})
+
+	t.Run("weighted random", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := 0; i < 7000; i++ {
+			s, _ := d.Get(WeightedRandomSelect)
+			counts[s]++
+		}
+		_assert(counts["a"] > counts["b"]*3 && counts["a"] > counts["c"]*3, "unexpected distribution %v", counts)
+	})
+
+	t.Run("update weights at runtime", func(t *testing.T) {
+		_ = d.UpdateWeights(map[string]int{"a": 0})
+		seen := make(map[string]bool)
+		for i := 0; i < 10; i++ {
+			s, _ := d.Get(WeightedRoundRobinSelect)
+			seen[s] = true
+		}
+		_assert(!seen["a"] && seen["b"] && seen["c"], "server with weight 0 shouldn't be selected, got %v", seen)
+		_assert(fmt.Sprint(d.Weights()) == "map[a:0 b:1 c:1]", "unexpected weights %v", d.Weights())
+	})
+}
+
+func TestMultiServiceDiscovery_Subscribe(t *testing.T) {
+	d := NewMultiServiceDiscovery([]string{"a", "b"})
+	events := make(chan DiscoveryEvent, 10)
+	unsubscribe := d.Subscribe(func(event DiscoveryEvent) { events <- event })
+
+	event := <-events
+	_assert(fmt.Sprint(event.Added) == "[a b]" && len(event.Removed) == 0, "expect current servers added, got %+v", event)
+	_ = d.Update([]string{"b", "c"})
+	event = <-events
+	_assert(fmt.Sprint(event.Added) == "[c]" && fmt.Sprint(event.Removed) == "[a]", "expect c added and a removed, got %+v", event)
+	_ = d.Update([]string{"c", "b"})
+
+	unsubscribe()
+	_ = d.Update(nil)
+	select {
+	case event = <-events:
+		t.Fatalf("expect no event, got %+v", event)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestXClient_Subscribe(t *testing.T) {
+	_, a := startServer()
+	_, b := startServer()
+	d := NewMultiServiceDiscovery([]string{a})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.EnablePrewarm()
+
+	clients := func() int {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		return len(xc.clients)
+	}
+	waitClients := func(n int) bool {
+		deadline := time.Now().Add(time.Second)
+		for clients() != n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+		}
+		return clients() == n
+	}
+	_assert(waitClients(1), "expect a pre-warmed, got %d clients", clients())
+
+	_ = d.Update([]string{a, b})
+	_assert(waitClients(2), "expect b pre-warmed, got %d clients", clients())
+
+	_ = d.Update([]string{b})
+	_assert(waitClients(1), "expect connection to removed a closed, got %d clients", clients())
+	xc.mu.Lock()
+	_, ok := xc.clients[b]
+	xc.mu.Unlock()
+	_assert(ok, "expect connection to b kept")
+}
+
+func TestXClient_ForgetRemoved(t *testing.T) {
+	d := NewMultiServiceDiscovery([]string{"a", "b"})
+	xc := NewXClient(d, LeastActiveSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(DefaultBreakerOption)
+	for _, server := range []string{"a", "b"} {
+		xc.breakers.get(server)
+		xc.selector.(Feedback).Start(server)
+		xc.selector.(Feedback).Finish(server, nil, time.Millisecond)
+	}
+
+	_ = d.Update([]string{"b"})
+	deadline := time.Now().Add(time.Second)
+	for (len(xc.Breakers()) != 1 || len(xc.Loads()) != 1) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	breakers, loads := xc.Breakers(), xc.Loads()
+	_assert(len(breakers) == 1 && breakers[0].Addr == "b", "expect the breaker of a dropped, got %+v", breakers)
+	_assert(len(loads) == 1 && loads[0].Addr == "b", "expect the load of a dropped, got %+v", loads)
+}
+
+type Sleeper int
+
+func (Sleeper) Sleep(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func TestXClient_DrainRemoved(t *testing.T) {
+	server, a := startServer()
+	_ = server.Register(new(Sleeper))
+	d := NewMultiServiceDiscovery([]string{a})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	called := make(chan error)
+	go func() {
+		var reply int
+		called <- xc.Call(context.Background(), "Sleeper.Sleep", time.Millisecond*300, &reply)
+	}()
+	time.Sleep(time.Millisecond * 100)
+	_ = d.Update([]string{})
+	time.Sleep(time.Millisecond * 50)
+	xc.mu.Lock()
+	draining := len(xc.draining)
+	xc.mu.Unlock()
+	_assert(draining == 1, "expect the connection to the removed server draining, got %d", draining)
+
+	err := <-called
+	_assert(err == nil, "expect the call in flight done, got %v", err)
+	xc.mu.Lock()
+	draining, clients := len(xc.draining), len(xc.clients)
+	xc.mu.Unlock()
+	_assert(draining == 0 && clients == 0, "expect the drained connection closed, got %d %d", draining, clients)
+
+	// a server removed while dialing, e.g. by prewarm, isn't cached
+	client, release, err := xc.dial(a)
+	_assert(err == nil, "dial error: %v", err)
+	xc.mu.Lock()
+	clients = len(xc.clients)
+	xc.mu.Unlock()
+	_assert(clients == 0, "expect no connection cached to a removed server, got %d", clients)
+	release()
+	_assert(!client.IsAvailable(), "expect the connection closed once released")
+}
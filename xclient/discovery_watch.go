@@ -0,0 +1,133 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// GeeRegistryWatchDiscovery is a GeeRegistryDiscovery which watches the registry by long polls,
+// servers are updated as soon as they change instead of every timeout.
+//
+// When the watch breaks, e.g. the registry is down or doesn't support watch, it falls back
+// to polling like GeeRegistryDiscovery and keeps retrying the watch with backoff,
+// on the next registry of the cluster if any.
+type GeeRegistryWatchDiscovery struct {
+	*GeeRegistryDiscovery
+	client   *http.Client
+	watching bool // the last watch succeeded, protected by mu of GeeRegistryDiscovery
+	ctx      context.Context
+	cancel   context.CancelFunc // stop watching
+	once     sync.Once          // start watching only once
+}
+
+const (
+	defaultWatchTimeout = time.Second * 30 // max time the registry holds a watch
+	minWatchBackoff     = time.Second
+)
+
+func NewGeeRegistryWatchDiscovery(registerAddr string, timeout time.Duration) *GeeRegistryWatchDiscovery {
+	return NewGeeRegistryClusterWatchDiscovery([]string{registerAddr}, timeout)
+}
+
+// NewGeeRegistryClusterWatchDiscovery is like NewGeeRegistryClusterDiscovery, but watches the registry.
+func NewGeeRegistryClusterWatchDiscovery(registries []string, timeout time.Duration) *GeeRegistryWatchDiscovery {
+	return NewGeeRegistryServiceWatchDiscovery(registries, "", "", timeout)
+}
+
+// NewGeeRegistryServiceWatchDiscovery is like NewGeeRegistryServiceDiscovery, but watches the registry.
+func NewGeeRegistryServiceWatchDiscovery(registries []string, namespace, service string, timeout time.Duration) *GeeRegistryWatchDiscovery {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &GeeRegistryWatchDiscovery{
+		GeeRegistryDiscovery: NewGeeRegistryServiceDiscovery(registries, namespace, service, timeout),
+		client:               &http.Client{Timeout: defaultWatchTimeout + defaultUpdateTimeout},
+		ctx:                  ctx,
+		cancel:               cancel,
+	}
+	go d.watch()
+	return d
+}
+
+// Close stops watching the registry.
+func (d *GeeRegistryWatchDiscovery) Close() error {
+	d.cancel()
+	return d.GeeRegistryDiscovery.Close()
+}
+
+// Refresh polls the registry only when the watch is broken.
+func (d *GeeRegistryWatchDiscovery) Refresh() error {
+	d.mu.RLock()
+	watching := d.watching
+	d.mu.RUnlock()
+	if watching {
+		return nil
+	}
+	return d.GeeRegistryDiscovery.Refresh()
+}
+
+func (d *GeeRegistryWatchDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServiceDiscovery.Get(mode)
+}
+
+func (d *GeeRegistryWatchDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServiceDiscovery.GetAll()
+}
+
+// watch keeps long polling the registry until d is closed.
+func (d *GeeRegistryWatchDiscovery) watch() {
+	backoff := minWatchBackoff
+	for {
+		err := d.watchOnce()
+		if d.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			backoff = minWatchBackoff
+			continue
+		}
+
+		log.Println("rpc registry: watch err:", err)
+		d.mu.Lock()
+		d.watching = false
+		d.failover()
+		d.mu.Unlock()
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > d.timeout {
+			backoff = d.timeout
+		}
+	}
+}
+
+// watchOnce waits for a revision different from the known one and updates servers.
+func (d *GeeRegistryWatchDiscovery) watchOnce() error {
+	d.mu.RLock()
+	registryAddr, revision := d.registry(), d.revision
+	d.mu.RUnlock()
+
+	list, err := d.fetch(d.ctx, d.client, registryAddr, true, revision)
+	if err != nil {
+		return err
+	}
+	if list.Revision == 0 {
+		return fmt.Errorf("rpc registry: %s doesn't support watch", registryAddr)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.update(list)
+	d.watching = true
+	return nil
+}
@@ -0,0 +1,166 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"geerpc/registry"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitServers waits until d has n servers.
+func waitServers(d Discovery, n int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		servers, _ := d.GetAll()
+		if len(servers) == n || time.Now().After(deadline) {
+			return servers
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestGeeRegistryWatchDiscovery(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Second))
+	defer ts.Close()
+	register := func(addr string) {
+		req, _ := http.NewRequest("POST", ts.URL, nil)
+		req.Header.Set("X-Geerpc-Servers", addr)
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "register error: %v", err)
+		_ = resp.Body.Close()
+	}
+
+	d := NewGeeRegistryWatchDiscovery(ts.URL, time.Minute)
+	defer func() { _ = d.Close() }()
+	_ = waitServers(d, 0, time.Second)
+
+	register("tcp@a")
+	servers := waitServers(d, 1, time.Second)
+	_assert(len(servers) == 1 && servers[0] == "tcp@a", "expect tcp@a pushed at once, got %v", servers)
+
+	register("tcp@b")
+	servers = waitServers(d, 2, time.Second)
+	_assert(len(servers) == 2, "expect 2 servers, got %v", servers)
+
+	// no heartbeat anymore, servers expire after 1s
+	servers = waitServers(d, 0, time.Second*3)
+	_assert(len(servers) == 0, "expect expired servers removed, got %v", servers)
+}
+
+func TestGeeRegistryClusterDiscovery(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	r := registry.New(time.Minute)
+	defer func() { _ = r.Close() }()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	req, _ := http.NewRequest("POST", ts.URL, nil)
+	req.Header.Set("X-Geerpc-Servers", "tcp@a")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "register error: %v", err)
+	_ = resp.Body.Close()
+
+	d := NewGeeRegistryClusterDiscovery([]string{down.URL, ts.URL}, 0)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1, "expect failover to the second registry, got %v %v", servers, err)
+
+	w := NewGeeRegistryClusterWatchDiscovery([]string{down.URL, ts.URL}, time.Minute)
+	defer func() { _ = w.Close() }()
+	servers = waitServers(w, 1, time.Second)
+	_assert(len(servers) == 1, "expect watch discovery fails over, got %v", servers)
+}
+
+func TestGeeRegistryServiceDiscovery(t *testing.T) {
+	r := registry.New(time.Minute)
+	defer func() { _ = r.Close() }()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	for _, item := range []registry.ServerItem{
+		{Addr: "tcp@a", Namespace: "prod", Services: []string{"Foo"}},
+		{Addr: "tcp@b", Namespace: "prod", Services: []string{"Bar"}},
+		{Addr: "tcp@c", Namespace: "test", Services: []string{"Foo"}},
+	} {
+		h := registry.NewHeartbeater(ts.URL, item, nil)
+		_ = h.Start()
+		defer func() { _ = h.Stop() }()
+	}
+
+	d := NewGeeRegistryServiceDiscovery([]string{ts.URL}, "prod", "Foo", 0)
+	servers, _ := d.GetAll()
+	_assert(len(servers) == 1 && servers[0] == "tcp@a", "expect tcp@a serving Foo in prod, got %v", servers)
+
+	w := NewGeeRegistryServiceWatchDiscovery([]string{ts.URL}, "prod", "Bar", time.Minute)
+	defer func() { _ = w.Close() }()
+	servers = waitServers(w, 1, time.Second)
+	_assert(len(servers) == 1 && servers[0] == "tcp@b", "expect tcp@b serving Bar in prod, got %v", servers)
+}
+
+func TestGeeRegistryRPCDiscovery(t *testing.T) {
+	r := registry.New(time.Minute)
+	defer func() { _ = r.Close() }()
+	server := geerpc.NewServer()
+	_ = r.RegisterService(server)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	defer func() { _ = server.Shutdown(context.Background()) }()
+	addr := "tcp@" + l.Addr().String()
+
+	h := registry.NewHeartbeater(addr, registry.ServerItem{Addr: "tcp@a", Services: []string{"Foo"}}, nil)
+	_ = h.Start()
+
+	d := NewGeeRegistryServiceDiscovery([]string{addr}, "", "Foo", 0)
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1 && servers[0] == "tcp@a", "expect tcp@a listed over geerpc, got %v %v", servers, err)
+
+	w := NewGeeRegistryWatchDiscovery(addr, time.Minute)
+	defer func() { _ = w.Close() }()
+	servers = waitServers(w, 1, time.Second)
+	_assert(len(servers) == 1, "expect tcp@a watched over geerpc, got %v", servers)
+	_ = h.Stop()
+	servers = waitServers(w, 0, time.Second)
+	_assert(len(servers) == 0, "expect tcp@a deregistered pushed at once, got %v", servers)
+}
+
+func TestGeeRegistryDiscoveryUnhealthy(t *testing.T) {
+	d := NewGeeRegistryDiscovery("http://127.0.0.1:0", time.Minute)
+	d.mu.Lock()
+	d.update(&registry.ServerList{Servers: []registry.ServerItem{{Addr: "tcp@a"}, {Addr: "tcp@b", Unhealthy: true}}})
+	d.mu.Unlock()
+	servers, _ := d.GetAll()
+	_assert(len(servers) == 1 && servers[0] == "tcp@a", "expect unhealthy tcp@b skipped, got %v", servers)
+}
+
+func TestGeeRegistryDiscoveryRefreshUnlocked(t *testing.T) {
+	asked, release := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(asked)
+		<-release
+		w.Header().Set("X-Geerpc-Servers", "tcp@b")
+	}))
+	defer slow.Close()
+	d := NewGeeRegistryDiscovery(slow.URL, time.Millisecond)
+	_ = d.Update([]string{"tcp@a"})
+	time.Sleep(time.Millisecond * 5)
+	refreshed := make(chan error)
+	go func() { refreshed <- d.Refresh() }()
+
+	<-asked
+	servers, _ := d.MultiServiceDiscovery.GetAll()
+	_assert(len(servers) == 1 && servers[0] == "tcp@a", "expect servers readable while a registry is asked, got %v", servers)
+	close(release)
+	_assert(<-refreshed == nil, "refresh error")
+	servers, _ = d.MultiServiceDiscovery.GetAll()
+	_assert(len(servers) == 1 && servers[0] == "tcp@b", "expect servers refreshed, got %v", servers)
+
+	func() {
+		defer func() {
+			_assert(recover() != nil, "expect a panic without registries")
+		}()
+		NewGeeRegistryClusterDiscovery(nil, 0)
+	}()
+}
@@ -0,0 +1,130 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// defaultReplicas is the number of virtual nodes of every server on the hash ring.
+const defaultReplicas = 100
+
+// Hash maps bytes to uint32
+type Hash func(data []byte) uint32
+
+// hashRing is a consistent hash ring with virtual nodes.
+//
+// When a server joins or leaves, only keys on the arcs owned by its virtual nodes are remapped.
+type hashRing struct {
+	hash     Hash
+	replicas int
+	servers  []string       // servers the ring is built from, in discovery order
+	keys     []int          // sorted hashes of virtual nodes
+	hashMap  map[int]string // virtual node hash -> server
+}
+
+func newHashRing(servers []string, replicas int, fn Hash) *hashRing {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	r := &hashRing{
+		hash:     fn,
+		replicas: replicas,
+		servers:  append([]string(nil), servers...),
+		hashMap:  make(map[int]string),
+	}
+	for _, server := range servers {
+		for i := 0; i < replicas; i++ {
+			h := int(r.hash([]byte(server + "#" + strconv.Itoa(i))))
+			r.keys = append(r.keys, h)
+			r.hashMap[h] = server
+		}
+	}
+	sort.Ints(r.keys)
+	return r
+}
+
+// builtFrom reports whether the ring is built from exactly servers.
+func (r *hashRing) builtFrom(servers []string) bool {
+	if len(r.servers) != len(servers) {
+		return false
+	}
+	for i := range servers {
+		if r.servers[i] != servers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the closest server after key on the ring.
+func (r *hashRing) get(key string) string {
+	return r.getAvailable(key, nil)
+}
+
+// getAvailable returns the closest server after key on the ring for which available returns true,
+// nil available means all servers are. Keys of an unavailable server move to the next servers
+// on the ring, and come back when it's available again. It returns "" if none is available.
+func (r *hashRing) getAvailable(key string, available func(server string) bool) string {
+	if len(r.keys) == 0 {
+		return ""
+	}
+	h := int(r.hash([]byte(key)))
+	idx := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	for i := 0; i < len(r.keys); i++ {
+		// wrap around the ring
+		server := r.hashMap[r.keys[(idx+i)%len(r.keys)]]
+		if available == nil || available(server) {
+			return server
+		}
+	}
+	return ""
+}
+
+type routingKey struct{}
+
+// WithRoutingKey returns a copy of ctx carrying the routing key used by ConsistentHashSelect.
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKey{}, key)
+}
+
+// RoutingKeyFrom returns the routing key stored in ctx by WithRoutingKey.
+func RoutingKeyFrom(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(routingKey{}).(string)
+	return key, ok
+}
+
+// KeyFunc extracts the routing key from the arguments of a call.
+type KeyFunc func(serviceMethod string, args interface{}) string
+
+type consistentHashSelector struct {
+	mu   sync.Mutex
+	ring *hashRing // rebuilt lazily when servers change
+}
+
+func NewConsistentHashSelector() Selector {
+	return &consistentHashSelector{}
+}
+
+// Select picks the server owning info.Key on the consistent hash ring.
+func (s *consistentHashSelector) Select(servers []string, info *SelectInfo) (string, error) {
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	if info.Key == "" {
+		return "", errors.New("rpc discovery: consistent hash select requires a routing key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ring == nil || !s.ring.builtFrom(servers) {
+		s.ring = newHashRing(servers, defaultReplicas, nil)
+	}
+	server := s.ring.getAvailable(info.Key, info.Available)
+	if server == "" {
+		return "", errNoServers
+	}
+	return server, nil
+}
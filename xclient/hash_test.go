@@ -0,0 +1,68 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHashRing(t *testing.T) {
+	servers := []string{"tcp@10.0.0.1:9999", "tcp@10.0.0.2:9999", "tcp@10.0.0.3:9999"}
+	before := newHashRing(servers, defaultReplicas, nil)
+	after := newHashRing(append(servers, "tcp@10.0.0.4:9999"), defaultReplicas, nil)
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := "user" + strconv.Itoa(i)
+		_assert(before.get(key) == before.get(key), "same key should map to the same server")
+		if s := after.get(key); s != before.get(key) {
+			_assert(s == "tcp@10.0.0.4:9999", "key %s should only move to the new server, got %s", key, s)
+			moved++
+		}
+	}
+	_assert(moved > 0 && moved < 500, "expect about a quarter of keys moved, got %d", moved)
+}
+
+func TestXClient_ConsistentHash(t *testing.T) {
+	servers := []string{"tcp@10.0.0.1:9999", "tcp@10.0.0.2:9999"}
+	xc := NewXClient(NewMultiServiceDiscovery(servers), ConsistentHashSelect, nil)
+
+	_, _, err := xc.selectServer(context.Background(), "Foo.Sum", nil)
+	_assert(err != nil, "expect an error without routing key")
+
+	ctx := WithRoutingKey(context.Background(), "user1")
+	s1, _, _ := xc.selectServer(ctx, "Foo.Sum", nil)
+	s2, _ := NewConsistentHashSelector().Select(servers, &SelectInfo{Key: "user1"})
+	_assert(s1 == s2, "routing key from context should be used")
+
+	xc.SetKeyFunc(func(serviceMethod string, args interface{}) string { return args.(string) })
+	s3, _, _ := xc.selectServer(context.Background(), "Foo.Sum", "user1")
+	_assert(s3 == s1, "routing key from key func should be used")
+}
+
+func TestXClient_ConsistentHashUnavailable(t *testing.T) {
+	servers := []string{"tcp@10.0.0.1:9999", "tcp@10.0.0.2:9999", "tcp@10.0.0.3:9999"}
+	xc := NewXClient(NewMultiServiceDiscovery(servers), ConsistentHashSelect, nil)
+	xc.SetBreaker(&BreakerOption{MaxFailures: 1, OpenTimeout: time.Minute})
+	selectKey := func(key string) string {
+		s, _, _ := xc.selectServer(WithRoutingKey(context.Background(), key), "Foo.Sum", nil)
+		return s
+	}
+	before := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := "user" + strconv.Itoa(i)
+		before[key] = selectKey(key)
+	}
+	ring := xc.selector.(*consistentHashSelector).ring
+
+	broken := before["user0"]
+	xc.breakers.get(broken).done(errors.New("failed"))
+	for key, s := range before {
+		after := selectKey(key)
+		_assert(after != broken, "expect %s skipped when its breaker is open", broken)
+		_assert(s == broken || after == s, "expect %s kept on %s, got %s", key, s, after)
+	}
+	_assert(xc.selector.(*consistentHashSelector).ring == ring, "expect the ring kept while a server is unavailable")
+}
@@ -0,0 +1,205 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"geerpc"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthCheckOption configures the active health checking of a XClient.
+type HealthCheckOption struct {
+	Interval           time.Duration // time between two rounds of checks
+	Timeout            time.Duration // timeout of a single check
+	ServiceMethod      string        // health method, geerpc.HealthCheckMethod by default
+	Service            string        // service whose status is asked, "" means the whole server
+	UnhealthyThreshold int           // consecutive failures to mark a server unhealthy
+	HealthyThreshold   int           // consecutive successes to restore an unhealthy server
+	// OnChange is called when a server is marked unhealthy or restored, it must not block.
+	OnChange func(addr string, healthy bool)
+}
+
+var DefaultHealthCheckOption = &HealthCheckOption{
+	Interval:           time.Second * 10,
+	Timeout:            time.Second * 3,
+	ServiceMethod:      geerpc.HealthCheckMethod,
+	UnhealthyThreshold: 3,
+	HealthyThreshold:   2,
+}
+
+type healthState struct {
+	healthy   bool
+	failures  int // consecutive failures
+	successes int // consecutive successes
+	lastErr   error
+	lastCheck time.Time
+}
+
+// HealthStat is a snapshot of the health of a server.
+type HealthStat struct {
+	Addr      string
+	Healthy   bool
+	LastErr   error
+	LastCheck time.Time
+}
+
+// healthChecker periodically calls the health method on every server of discovery.
+type healthChecker struct {
+	xc     *XClient
+	opt    *HealthCheckOption
+	mu     sync.Mutex // protect following
+	states map[string]*healthState
+	once   sync.Once // close done only once
+	done   chan struct{}
+}
+
+// newHealthChecker creates a checker with a copy of opt, whose zero fields are taken from DefaultHealthCheckOption.
+func newHealthChecker(xc *XClient, opt *HealthCheckOption) *healthChecker {
+	o := *opt
+	if o.Interval <= 0 {
+		o.Interval = DefaultHealthCheckOption.Interval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultHealthCheckOption.Timeout
+	}
+	if o.ServiceMethod == "" {
+		o.ServiceMethod = geerpc.HealthCheckMethod
+	}
+	if o.UnhealthyThreshold <= 0 {
+		o.UnhealthyThreshold = DefaultHealthCheckOption.UnhealthyThreshold
+	}
+	if o.HealthyThreshold <= 0 {
+		o.HealthyThreshold = DefaultHealthCheckOption.HealthyThreshold
+	}
+	return &healthChecker{
+		xc:     xc,
+		opt:    &o,
+		states: make(map[string]*healthState),
+		done:   make(chan struct{}),
+	}
+}
+
+func (h *healthChecker) run() {
+	t := time.NewTicker(h.opt.Interval)
+	defer t.Stop()
+	for {
+		h.checkAll()
+		select {
+		case <-h.done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (h *healthChecker) stop() {
+	h.once.Do(func() { close(h.done) })
+}
+
+// checkAll checks every server concurrently and forgets servers removed from discovery.
+func (h *healthChecker) checkAll() {
+	servers, err := h.xc.d.GetAll()
+	if err != nil {
+		log.Println("rpc xclient: health check get servers error:", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			h.report(rpcAddr, h.check(rpcAddr))
+		}(rpcAddr)
+	}
+	wg.Wait()
+
+	alive := make(map[string]bool, len(servers))
+	for _, rpcAddr := range servers {
+		alive[rpcAddr] = true
+	}
+	h.mu.Lock()
+	for rpcAddr := range h.states {
+		if !alive[rpcAddr] {
+			delete(h.states, rpcAddr)
+		}
+	}
+	h.mu.Unlock()
+}
+
+// check calls the health method on rpcAddr using the cached client of xc.
+func (h *healthChecker) check(rpcAddr string) error {
+	client, release, err := h.xc.dial(rpcAddr)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), h.opt.Timeout)
+	defer cancel()
+	var reply geerpc.HealthCheckReply
+	if err := client.Call(ctx, h.opt.ServiceMethod, geerpc.HealthCheckArgs{Service: h.opt.Service}, &reply); err != nil {
+		return err
+	}
+	if reply.Status != geerpc.StatusServing {
+		return fmt.Errorf("rpc xclient: server status %s", reply.Status)
+	}
+	return nil
+}
+
+func (h *healthChecker) report(rpcAddr string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.states[rpcAddr]
+	if !ok {
+		s = &healthState{healthy: true}
+		h.states[rpcAddr] = s
+	}
+	s.lastErr = err
+	s.lastCheck = time.Now()
+	if err != nil {
+		s.successes = 0
+		s.failures++
+		if s.healthy && s.failures >= h.opt.UnhealthyThreshold {
+			s.healthy = false
+			log.Printf("rpc xclient: %s is unhealthy: %v", rpcAddr, err)
+			if h.opt.OnChange != nil {
+				h.opt.OnChange(rpcAddr, false)
+			}
+		}
+		return
+	}
+	s.failures = 0
+	s.successes++
+	if !s.healthy && s.successes >= h.opt.HealthyThreshold {
+		s.healthy = true
+		log.Printf("rpc xclient: %s is healthy again", rpcAddr)
+		if h.opt.OnChange != nil {
+			h.opt.OnChange(rpcAddr, true)
+		}
+	}
+}
+
+// healthy reports whether rpcAddr may be selected, servers never checked are healthy.
+func (h *healthChecker) healthy(rpcAddr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.states[rpcAddr]
+	return !ok || s.healthy
+}
+
+func (h *healthChecker) stats() []HealthStat {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make([]HealthStat, 0, len(h.states))
+	for addr, s := range h.states {
+		stats = append(stats, HealthStat{Addr: addr, Healthy: s.healthy, LastErr: s.lastErr, LastCheck: s.lastCheck})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
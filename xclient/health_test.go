@@ -0,0 +1,57 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"net"
+	"testing"
+	"time"
+)
+
+func startServer() (*geerpc.Server, string) {
+	server := geerpc.NewServer()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	return server, "tcp@" + l.Addr().String()
+}
+
+func TestXClient_HealthCheck(t *testing.T) {
+	server, live := startServer()
+	dead := "tcp@127.0.0.1:1"
+
+	d := NewMultiServiceDiscovery([]string{live, dead})
+	xc := NewXClient(d, RoundRobinSelect, &geerpc.Option{ConnectTimeout: time.Second})
+	defer func() { _ = xc.Close() }()
+	changed := make(chan bool, 10)
+	xc.EnableHealthCheck(&HealthCheckOption{
+		Interval:           time.Millisecond * 50,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   2,
+		OnChange:           func(addr string, healthy bool) { changed <- healthy },
+	})
+
+	_assert(!<-changed, "expect the dead server marked unhealthy")
+	for i := 0; i < 4; i++ {
+		s, _, err := xc.selectServer(context.Background(), "Foo.Sum", nil)
+		_assert(err == nil && s == live, "expect only the live server selected, got %s %v", s, err)
+	}
+
+	server.Health().SetServingStatus("", geerpc.StatusNotServing)
+	_assert(!<-changed, "expect the not serving server marked unhealthy")
+	_, _, err := xc.selectServer(context.Background(), "Foo.Sum", nil)
+	_assert(err == ErrNoAvailableServer, "expect no available server, got %v", err)
+
+	server.Health().SetServingStatus("", geerpc.StatusServing)
+	_assert(<-changed, "expect the server restored")
+}
+
+func TestNewHealthChecker_Defaults(t *testing.T) {
+	opt := &HealthCheckOption{Interval: time.Second}
+	h := newHealthChecker(nil, opt)
+	_assert(h.opt.Interval == time.Second, "expect Interval kept")
+	_assert(h.opt.Timeout == DefaultHealthCheckOption.Timeout, "expect default Timeout, got %s", h.opt.Timeout)
+	_assert(h.opt.ServiceMethod == geerpc.HealthCheckMethod, "expect default ServiceMethod")
+	_assert(h.opt.UnhealthyThreshold == DefaultHealthCheckOption.UnhealthyThreshold, "expect default UnhealthyThreshold")
+	_assert(opt.Timeout == 0 && opt.ServiceMethod == "", "expect opt of the caller unchanged")
+}
@@ -0,0 +1,214 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// decay time of latency EWMA, samples older than it weigh less than 1/e
+const ewmaDecay = time.Second * 10
+
+// minimum latency a failed call counts for, so a server failing fast isn't preferred
+const errorPenalty = time.Second
+
+// addrLoad is the load observed by XClient on a server.
+type addrLoad struct {
+	inflight int64         // calls not finished yet
+	ewma     time.Duration // exponentially weighted moving average of latency
+	lastDone time.Time     // last time ewma was updated
+	// forgotten means the server was removed, the load is dropped once calls in flight are done.
+	forgotten bool
+}
+
+// score estimates the latency of a new call to the server, the lower the better.
+func (l *addrLoad) score() float64 {
+	return float64(l.inflight+1) * float64(l.ewma+1)
+}
+
+// loadTracker tracks in-flight calls and latency of every server.
+type loadTracker struct {
+	mu    sync.Mutex // protect following
+	r     *rand.Rand
+	loads map[string]*addrLoad
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{
+		r:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		loads: make(map[string]*addrLoad),
+	}
+}
+
+func (t *loadTracker) load(addr string) *addrLoad {
+	l, ok := t.loads[addr]
+	if !ok {
+		l = new(addrLoad)
+		t.loads[addr] = l
+	}
+	return l
+}
+
+// Start is called before a call is sent to addr.
+func (t *loadTracker) Start(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.load(addr)
+	l.inflight++
+	l.forgotten = false
+}
+
+// Finish is called after a call to addr is done. A failed call counts for the larger of
+// errorPenalty and twice the latency EWMA, a call canceled by its caller counts as it is.
+func (t *loadTracker) Finish(addr string, err error, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.loads[addr]
+	if !ok {
+		return // not started, or forgotten without calls in flight
+	}
+	l.inflight--
+	if l.forgotten && l.inflight <= 0 {
+		delete(t.loads, addr)
+		return
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		penalty := errorPenalty
+		if penalty < l.ewma*2 {
+			penalty = l.ewma * 2
+		}
+		if latency < penalty {
+			latency = penalty
+		}
+	}
+	now := time.Now()
+	if l.lastDone.IsZero() {
+		l.ewma = latency
+	} else {
+		w := math.Exp(-float64(now.Sub(l.lastDone)) / float64(ewmaDecay))
+		l.ewma = time.Duration(float64(l.ewma)*w + float64(latency)*(1-w))
+	}
+	l.lastDone = now
+}
+
+// Forget drops the load of addr, e.g. when addr is removed from discovery.
+// The load is kept until calls in flight to addr are done, so they are counted
+// if addr comes back meanwhile.
+func (t *loadTracker) Forget(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.loads[addr]
+	if !ok {
+		return
+	}
+	if l.inflight > 0 {
+		l.forgotten = true
+		return
+	}
+	delete(t.loads, addr)
+}
+
+// leastActiveSelector selects the server with the fewest in-flight calls,
+// it learns the load of servers from the feedback of XClient.
+type leastActiveSelector struct {
+	*loadTracker
+}
+
+func NewLeastActiveSelector() Selector {
+	return leastActiveSelector{newLoadTracker()}
+}
+
+func (s leastActiveSelector) Select(servers []string, info *SelectInfo) (string, error) {
+	return s.leastActive(availableServers(servers, info))
+}
+
+// p2cSelector selects the less loaded of two random servers,
+// it learns the load of servers from the feedback of XClient.
+type p2cSelector struct {
+	*loadTracker
+}
+
+func NewP2CSelector() Selector {
+	return p2cSelector{newLoadTracker()}
+}
+
+func (s p2cSelector) Select(servers []string, info *SelectInfo) (string, error) {
+	return s.p2c(availableServers(servers, info))
+}
+
+var _ Feedback = (*loadTracker)(nil)
+
+// leastActive picks the server with the fewest in-flight calls,
+// ties are broken by lower latency and then randomly.
+func (t *loadTracker) leastActive(servers []string) (string, error) {
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var best []string
+	var bestLoad *addrLoad
+	for _, server := range servers {
+		l := t.load(server)
+		switch {
+		case bestLoad == nil || l.inflight < bestLoad.inflight ||
+			(l.inflight == bestLoad.inflight && l.ewma < bestLoad.ewma):
+			best, bestLoad = []string{server}, l
+		case l.inflight == bestLoad.inflight && l.ewma == bestLoad.ewma:
+			best = append(best, server)
+		}
+	}
+	return best[t.r.Intn(len(best))], nil
+}
+
+// p2c picks two servers randomly and chooses the less loaded one (power of two choices),
+// it costs O(1) regardless of the number of servers.
+func (t *loadTracker) p2c(servers []string) (string, error) {
+	n := len(servers)
+	switch n {
+	case 0:
+		return "", errNoServers
+	case 1:
+		return servers[0], nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.r.Intn(n)
+	j := t.r.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	a, b := servers[i], servers[j]
+	if t.load(b).score() < t.load(a).score() {
+		return b, nil
+	}
+	return a, nil
+}
+
+// LoadStat is a snapshot of the load XClient observed on a server.
+type LoadStat struct {
+	Addr     string
+	Inflight int64
+	Latency  time.Duration // EWMA of latency
+}
+
+// Loads returns the in-flight calls and latency EWMA of every server.
+func (t *loadTracker) Loads() []LoadStat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make([]LoadStat, 0, len(t.loads))
+	for addr, l := range t.loads {
+		stats = append(stats, LoadStat{Addr: addr, Inflight: l.inflight, Latency: l.ewma})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
@@ -0,0 +1,80 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadTracker(t *testing.T) {
+	lt := newLoadTracker()
+	servers := []string{"a", "b", "c"}
+	lt.Start("a")
+	lt.Start("b")
+	lt.Start("b")
+
+	t.Run("least active", func(t *testing.T) {
+		s, _ := lt.leastActive(servers)
+		_assert(s == "c", "expect c which has no in-flight call, got %s", s)
+
+		lt.Start("c")
+		lt.Start("c")
+		s, _ = lt.leastActive(servers)
+		_assert(s == "a", "expect a which has 1 in-flight call, got %s", s)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		lt.Finish("b", nil, time.Second)
+		lt.Finish("c", nil, time.Millisecond)
+		s, _ := lt.leastActive([]string{"b", "c"})
+		_assert(s == "c", "expect c with same in-flight calls but lower latency, got %s", s)
+	})
+
+	t.Run("p2c", func(t *testing.T) {
+		lt.Finish("a", nil, time.Millisecond*100)
+		for i := 0; i < 5; i++ {
+			lt.Start("a")
+		}
+		for i := 0; i < 10; i++ {
+			s, _ := lt.p2c([]string{"a", "c"})
+			_assert(s == "c", "expect c which is less loaded, got %s", s)
+		}
+	})
+}
+
+func TestLoadTracker_ErrorPenalty(t *testing.T) {
+	lt := newLoadTracker()
+	lt.Start("a")
+	lt.Finish("a", errors.New("connection refused"), time.Millisecond)
+	lt.Start("b")
+	lt.Finish("b", nil, time.Millisecond*100)
+	s, _ := lt.leastActive([]string{"a", "b"})
+	_assert(s == "b", "expect b slower than the failing a, got %s", s)
+
+	lt.Start("c")
+	lt.Finish("c", context.Canceled, time.Millisecond)
+	s, _ = lt.leastActive([]string{"b", "c"})
+	_assert(s == "c", "expect no penalty for a canceled call, got %s", s)
+}
+
+func TestLoadTracker_Forget(t *testing.T) {
+	lt := newLoadTracker()
+	lt.Start("a")
+	lt.Forget("a")
+	loads := lt.Loads()
+	_assert(len(loads) == 1 && loads[0].Inflight == 1, "expect the load kept while a call is in flight, got %+v", loads)
+	lt.Finish("a", nil, time.Millisecond)
+	_assert(len(lt.Loads()) == 0, "expect the load dropped once the call is done, got %+v", lt.Loads())
+
+	lt.Finish("b", nil, time.Millisecond)
+	_assert(len(lt.Loads()) == 0, "expect unknown addresses ignored, got %+v", lt.Loads())
+
+	// a forgotten server coming back while its call is in flight
+	lt.Start("c")
+	lt.Forget("c")
+	lt.Start("c")
+	lt.Finish("c", nil, time.Millisecond)
+	loads = lt.Loads()
+	_assert(len(loads) == 1 && loads[0].Inflight == 1, "expect calls of c counted, got %+v", loads)
+}
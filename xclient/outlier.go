@@ -0,0 +1,272 @@
+package xclient
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutlierOption configures outlier detection of a XClient, it works like Envoy's.
+//
+// Every Interval, the success rate and mean latency of each server with at least MinRequests
+// calls are compared with the pool. A server whose success rate is below mean - StdevFactor * stdev,
+// or whose latency is above mean + StdevFactor * stdev, is ejected from selection for
+// BaseEjectionTime multiplied by the number of times it was ejected, up to MaxEjectionTime.
+type OutlierOption struct {
+	Interval           time.Duration
+	BaseEjectionTime   time.Duration
+	MaxEjectionTime    time.Duration
+	MaxEjectionPercent int     // never eject more than this percent of servers, at least one is kept
+	MinRequests        int     // minimum calls in an interval for a server to be analyzed
+	MinHosts           int     // minimum analyzed servers to compute statistics of the pool
+	StdevFactor        float64 // how many standard deviations from the mean make an outlier
+	// OnEject is called when a server is ejected or restored, it must not block.
+	OnEject func(stat EjectionStat)
+}
+
+var DefaultOutlierOption = &OutlierOption{
+	Interval:           time.Second * 10,
+	BaseEjectionTime:   time.Second * 30,
+	MaxEjectionTime:    time.Minute * 5,
+	MaxEjectionPercent: 10,
+	MinRequests:        100,
+	MinHosts:           5,
+	StdevFactor:        1.9,
+}
+
+// EjectionStat is a snapshot of the outlier detection state of a server.
+type EjectionStat struct {
+	Addr    string
+	Ejected bool
+	Until   time.Time // end of the ejection
+	Times   int       // ejection multiplier, it decreases while the server behaves
+	Reason  string    // why the server was ejected last time
+}
+
+type outlierHost struct {
+	requests int
+	failures int
+	latency  time.Duration // sum of latency in the interval
+	ejected  bool
+	until    time.Time
+	times    int
+	reason   string
+}
+
+func (h *outlierHost) stat(addr string) EjectionStat {
+	return EjectionStat{Addr: addr, Ejected: h.ejected, Until: h.until, Times: h.times, Reason: h.reason}
+}
+
+// outlierDetector ejects servers which are statistically worse than the rest of the pool.
+type outlierDetector struct {
+	xc    *XClient
+	opt   *OutlierOption
+	mu    sync.Mutex // protect following
+	hosts map[string]*outlierHost
+	once  sync.Once // close done only once
+	done  chan struct{}
+}
+
+// newOutlierDetector creates a detector with a copy of opt, whose zero fields are taken from DefaultOutlierOption.
+func newOutlierDetector(xc *XClient, opt *OutlierOption) *outlierDetector {
+	o := *opt
+	if o.Interval <= 0 {
+		o.Interval = DefaultOutlierOption.Interval
+	}
+	if o.BaseEjectionTime <= 0 {
+		o.BaseEjectionTime = DefaultOutlierOption.BaseEjectionTime
+	}
+	if o.MaxEjectionTime <= 0 {
+		o.MaxEjectionTime = DefaultOutlierOption.MaxEjectionTime
+	}
+	if o.MaxEjectionPercent <= 0 {
+		o.MaxEjectionPercent = DefaultOutlierOption.MaxEjectionPercent
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = DefaultOutlierOption.MinRequests
+	}
+	if o.MinHosts <= 0 {
+		o.MinHosts = DefaultOutlierOption.MinHosts
+	}
+	if o.StdevFactor <= 0 {
+		o.StdevFactor = DefaultOutlierOption.StdevFactor
+	}
+	return &outlierDetector{
+		xc:    xc,
+		opt:   &o,
+		hosts: make(map[string]*outlierHost),
+		done:  make(chan struct{}),
+	}
+}
+
+func (o *outlierDetector) run() {
+	t := time.NewTicker(o.opt.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-t.C:
+			o.analyze()
+		}
+	}
+}
+
+func (o *outlierDetector) stop() {
+	o.once.Do(func() { close(o.done) })
+}
+
+func (o *outlierDetector) host(addr string) *outlierHost {
+	h, ok := o.hosts[addr]
+	if !ok {
+		h = new(outlierHost)
+		o.hosts[addr] = h
+	}
+	return h
+}
+
+// record counts the result of a call to addr in the current interval.
+func (o *outlierDetector) record(addr string, err error, latency time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	h := o.host(addr)
+	h.requests++
+	h.latency += latency
+	if err != nil {
+		h.failures++
+	}
+}
+
+// ejected reports whether addr is ejected from selection now.
+func (o *outlierDetector) ejected(addr string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	h, ok := o.hosts[addr]
+	return ok && h.ejected && time.Now().Before(h.until)
+}
+
+// meanStdev returns the mean and standard deviation of values.
+func meanStdev(values []float64) (mean, stdev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stdev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stdev / float64(len(values)))
+}
+
+// analyze restores servers whose ejection is over, ejects outliers of the last interval
+// and starts a new interval.
+func (o *outlierDetector) analyze() {
+	servers, err := o.xc.d.GetAll()
+	if err != nil {
+		log.Println("rpc xclient: outlier detection get servers error:", err)
+		return
+	}
+
+	o.mu.Lock()
+	var events []EjectionStat
+	defer func() {
+		o.mu.Unlock()
+		if o.opt.OnEject != nil {
+			for _, e := range events {
+				o.opt.OnEject(e)
+			}
+		}
+	}()
+
+	now := time.Now()
+	alive := make(map[string]bool, len(servers))
+	for _, addr := range servers {
+		alive[addr] = true
+	}
+	ejected := 0
+	for addr, h := range o.hosts {
+		switch {
+		case !alive[addr]:
+			delete(o.hosts, addr)
+		case h.ejected && !now.Before(h.until):
+			h.ejected = false
+			log.Printf("rpc xclient: outlier %s is restored", addr)
+			events = append(events, h.stat(addr))
+		case h.ejected:
+			ejected++
+		case h.times > 0 && h.requests > 0 && h.failures == 0:
+			h.times-- // behaves well for an interval, forgive it a bit
+		}
+	}
+
+	var addrs []string
+	var rates, latencies []float64
+	for addr, h := range o.hosts {
+		if !h.ejected && h.requests >= o.opt.MinRequests {
+			addrs = append(addrs, addr)
+			rates = append(rates, float64(h.requests-h.failures)/float64(h.requests))
+			latencies = append(latencies, float64(h.latency)/float64(h.requests))
+		}
+	}
+	if len(addrs) >= o.opt.MinHosts && len(addrs) > 0 {
+		// eject at least one server of a small pool, but never all servers, whatever MaxEjectionPercent is.
+		maxEjected := len(servers) * o.opt.MaxEjectionPercent / 100
+		if maxEjected < 1 {
+			maxEjected = 1
+		}
+		if maxEjected > len(servers)-1 {
+			maxEjected = len(servers) - 1
+		}
+		rateMean, rateStdev := meanStdev(rates)
+		latencyMean, latencyStdev := meanStdev(latencies)
+		// eject the worst first
+		idx := make([]int, len(addrs))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.Slice(idx, func(i, j int) bool { return rates[idx[i]] < rates[idx[j]] })
+		for _, i := range idx {
+			if ejected >= maxEjected {
+				break
+			}
+			var reason string
+			switch {
+			case rates[i] < rateMean-o.opt.StdevFactor*rateStdev:
+				reason = "success rate"
+			case latencies[i] > latencyMean+o.opt.StdevFactor*latencyStdev:
+				reason = "latency"
+			default:
+				continue
+			}
+			h := o.hosts[addrs[i]]
+			h.times++
+			d := o.opt.BaseEjectionTime * time.Duration(h.times)
+			if o.opt.MaxEjectionTime > 0 && d > o.opt.MaxEjectionTime {
+				d = o.opt.MaxEjectionTime
+			}
+			h.ejected, h.until, h.reason = true, now.Add(d), reason
+			ejected++
+			log.Printf("rpc xclient: outlier %s is ejected for %s by %s", addrs[i], d, reason)
+			events = append(events, h.stat(addrs[i]))
+		}
+	}
+
+	for _, h := range o.hosts {
+		h.requests, h.failures, h.latency = 0, 0, 0
+	}
+}
+
+func (o *outlierDetector) stats() []EjectionStat {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := make([]EjectionStat, 0, len(o.hosts))
+	for addr, h := range o.hosts {
+		stats = append(stats, h.stat(addr))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
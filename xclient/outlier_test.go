@@ -0,0 +1,77 @@
+package xclient
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	var servers []string
+	for i := 0; i < 10; i++ {
+		servers = append(servers, "tcp@10.0.0."+strconv.Itoa(i)+":9999")
+	}
+	xc := NewXClient(NewMultiServiceDiscovery(servers), RandomSelect, nil)
+	var events []EjectionStat
+	o := newOutlierDetector(xc, &OutlierOption{
+		BaseEjectionTime:   time.Minute,
+		MaxEjectionTime:    time.Minute * 5,
+		MaxEjectionPercent: 10,
+		MinRequests:        10,
+		MinHosts:           5,
+		StdevFactor:        1.9,
+		OnEject:            func(stat EjectionStat) { events = append(events, stat) },
+	})
+
+	failed := errors.New("failed")
+	for i, server := range servers {
+		for j := 0; j < 10; j++ {
+			var err error
+			if i < 2 && j < 8 { // 2 bad servers
+				err = failed
+			}
+			o.record(server, err, time.Millisecond)
+		}
+	}
+	o.analyze()
+
+	_assert(len(events) == 1, "expect 1 ejection capped by 10%%, got %d", len(events))
+	for _, e := range events {
+		_assert(e.Ejected && e.Reason == "success rate" && o.ejected(e.Addr), "unexpected event %+v", e)
+	}
+	_assert(!o.ejected(servers[5]), "good server shouldn't be ejected")
+
+	// the ejection is over
+	for _, e := range events {
+		o.hosts[e.Addr].until = time.Now()
+	}
+	events = nil
+	o.analyze()
+	_assert(len(events) == 1 && !events[0].Ejected, "expect ejected servers restored, got %+v", events)
+}
+
+func TestOutlierDetector_SmallPool(t *testing.T) {
+	var servers []string
+	for i := 0; i < 5; i++ {
+		servers = append(servers, "tcp@10.0.0."+strconv.Itoa(i)+":9999")
+	}
+	xc := NewXClient(NewMultiServiceDiscovery(servers), RandomSelect, nil)
+	opt := &OutlierOption{MinRequests: 10}
+	o := newOutlierDetector(xc, opt)
+	_assert(o.opt.Interval == DefaultOutlierOption.Interval && o.opt.MinHosts == DefaultOutlierOption.MinHosts,
+		"expect zero fields taken from DefaultOutlierOption, got %+v", o.opt)
+	_assert(opt.Interval == 0, "expect opt of the caller unchanged")
+
+	for i, server := range servers {
+		for j := 0; j < 10; j++ {
+			var err error
+			if i == 0 && j < 8 {
+				err = errors.New("failed")
+			}
+			o.record(server, err, time.Millisecond)
+		}
+	}
+	o.analyze()
+	_assert(o.ejected(servers[0]), "expect the bad server ejected although 10%% of 5 servers rounds to 0")
+}
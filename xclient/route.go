@@ -0,0 +1,127 @@
+package xclient
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Well-known labels of servers used by label-aware routing.
+const (
+	LabelZone    = "zone"
+	LabelVersion = "version"
+	LabelCanary  = "canary" // "true" marks a canary server
+)
+
+// RouteOption configures label-aware routing of a XClient, servers are filtered
+// by their labels before the selector chooses one.
+//
+// A call goes to the servers matching the first of Tiers with at least MinAvailable
+// available servers, i.e. healthy, not ejected and whose circuit breaker isn't open.
+// So callers stay in their own zone and fall back to others only when local capacity is unhealthy.
+type RouteOption struct {
+	// Tiers lists label sets in order of preference, a server matches a tier if it has all labels of the tier.
+	Tiers []map[string]string
+	// MinAvailable is the minimum available servers for a tier to be used, 0 means 1.
+	MinAvailable int
+	// Fallback uses all servers when no tier has enough available servers,
+	// otherwise such calls fail with ErrNoAvailableServer.
+	Fallback bool
+	// CanaryPercent is the percent of calls sent to servers labeled canary=true, other calls
+	// never go to canary servers. Tiers apply to canary servers too, calls go to
+	// normal servers if no canary server is available, and vice versa.
+	CanaryPercent int
+}
+
+// ZoneRoute returns a RouteOption preferring servers in zone and falling back to
+// servers in other zones when no server in zone is available.
+func ZoneRoute(zone string) *RouteOption {
+	return &RouteOption{
+		Tiers:    []map[string]string{{LabelZone: zone}},
+		Fallback: true,
+	}
+}
+
+// router filters servers by labels according to a RouteOption.
+type router struct {
+	opt *RouteOption
+	mu  sync.Mutex // protect r
+	r   *rand.Rand // decide which calls go to canary servers
+}
+
+func newRouter(opt *RouteOption) *router {
+	return &router{opt: opt, r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// matchLabels reports whether labels has all labels of want.
+func matchLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func isCanary(labels map[string]string) bool {
+	return labels[LabelCanary] == "true"
+}
+
+// canary decides whether a call goes to canary servers.
+func (rt *router) canary() bool {
+	if rt.opt.CanaryPercent <= 0 {
+		return false
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.r.Intn(100) < rt.opt.CanaryPercent
+}
+
+// route returns the servers a call may go to, servers are available servers and
+// labels are labels of servers. servers isn't modified.
+func (rt *router) route(servers []string, labels map[string]map[string]string) []string {
+	var canaries, stable []string
+	for _, server := range servers {
+		if isCanary(labels[server]) {
+			canaries = append(canaries, server)
+		} else {
+			stable = append(stable, server)
+		}
+	}
+	if rt.canary() {
+		if routed := rt.filter(canaries, labels); len(routed) > 0 {
+			return routed
+		}
+	}
+	if routed := rt.filter(stable, labels); len(routed) > 0 {
+		return routed
+	}
+	// no normal server is available, canary servers are better than nothing.
+	return rt.filter(canaries, labels)
+}
+
+// filter returns the servers of the first tier with enough servers.
+func (rt *router) filter(servers []string, labels map[string]map[string]string) []string {
+	if len(rt.opt.Tiers) == 0 {
+		return servers
+	}
+	minAvailable := rt.opt.MinAvailable
+	if minAvailable <= 0 {
+		minAvailable = 1
+	}
+	for _, tier := range rt.opt.Tiers {
+		var matched []string
+		for _, server := range servers {
+			if matchLabels(labels[server], tier) {
+				matched = append(matched, server)
+			}
+		}
+		if len(matched) >= minAvailable {
+			return matched
+		}
+	}
+	if rt.opt.Fallback {
+		return servers
+	}
+	return nil
+}
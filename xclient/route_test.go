@@ -0,0 +1,72 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	servers := []string{"a1", "a2", "b1", "c1"}
+	d := NewMultiServiceDiscovery(servers)
+	_ = d.UpdateLabels(map[string]map[string]string{
+		"a1": {LabelZone: "a"},
+		"a2": {LabelZone: "a", LabelCanary: "true"},
+		"b1": {LabelZone: "b"},
+		"c1": {LabelZone: "c"},
+	})
+	xc := NewXClient(d, RandomSelect, nil)
+	xc.SetBreaker(DefaultBreakerOption)
+	xc.SetRoute(ZoneRoute("a"))
+
+	t.Run("prefer zone", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			s, _, err := xc.selectServer(context.Background(), "Foo.Sum", nil)
+			_assert(err == nil && s == "a1", "expect a1 in zone a which isn't canary, got %s %v", s, err)
+		}
+	})
+
+	t.Run("canary", func(t *testing.T) {
+		xc.SetRoute(&RouteOption{Tiers: []map[string]string{{LabelZone: "a"}}, CanaryPercent: 100})
+		s, _, _ := xc.selectServer(context.Background(), "Foo.Sum", nil)
+		_assert(s == "a2", "expect canary a2, got %s", s)
+
+		rt := newRouter(&RouteOption{CanaryPercent: 30})
+		canaries := 0
+		for i := 0; i < 1000; i++ {
+			if routed := rt.route(servers, d.Labels()); len(routed) == 1 && routed[0] == "a2" {
+				canaries++
+			}
+		}
+		_assert(canaries > 200 && canaries < 400, "expect about 30%% of calls to canary, got %d/1000", canaries)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		xc.SetRoute(&RouteOption{
+			Tiers:    []map[string]string{{LabelZone: "a"}, {LabelZone: "b"}},
+			Fallback: true,
+		})
+		trip(xc.breakers.get("a1"))
+		s, _, _ := xc.selectServer(context.Background(), "Foo.Sum", nil)
+		_assert(s == "b1", "expect b1 in zone b when a1 is unavailable, got %s", s)
+
+		trip(xc.breakers.get("b1"))
+		s, _, _ = xc.selectServer(context.Background(), "Foo.Sum", nil)
+		_assert(s == "c1", "expect c1 when no tier is available, got %s", s)
+
+		xc.SetRoute(&RouteOption{Tiers: []map[string]string{{LabelZone: "a"}, {LabelZone: "b"}}})
+		s, _, _ = xc.selectServer(context.Background(), "Foo.Sum", nil)
+		_assert(s == "a2", "expect canary a2 when no normal server matches, got %s", s)
+
+		trip(xc.breakers.get("a2"))
+		_, _, err := xc.selectServer(context.Background(), "Foo.Sum", nil)
+		_assert(errors.Is(err, ErrNoAvailableServer), "expect ErrNoAvailableServer without fallback, got %v", err)
+	})
+}
+
+// trip opens b by consecutive failures.
+func trip(b *breaker) {
+	for i := 0; i < DefaultBreakerOption.MaxFailures; i++ {
+		b.done(errors.New("failed"))
+	}
+}
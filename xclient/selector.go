@@ -0,0 +1,213 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SelectInfo carries the context of a call a Selector may use.
+type SelectInfo struct {
+	Ctx           context.Context // context of the call, may carry metadata, nil when called by Discovery.Get
+	ServiceMethod string
+	Args          interface{}
+	Key           string         // routing key, "" if none
+	Weights       map[string]int // weight of servers, nil if discovery doesn't know
+	// Available reports whether a server may be selected, e.g. it is healthy and its circuit breaker
+	// isn't open, nil means all servers are available.
+	Available func(server string) bool
+}
+
+// available reports whether server may be selected.
+func (info *SelectInfo) available(server string) bool {
+	return info == nil || info.Available == nil || info.Available(server)
+}
+
+// availableServers returns the servers which may be selected, servers itself if all are.
+func availableServers(servers []string, info *SelectInfo) []string {
+	if info == nil || info.Available == nil {
+		return servers
+	}
+	available := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if info.Available(server) {
+			available = append(available, server)
+		}
+	}
+	return available
+}
+
+// Selector chooses a server for a call from the servers returned by Discovery.GetAll.
+//
+// servers are all servers of discovery, so a stateful Selector, e.g. a hash ring, is kept
+// while some servers are unavailable. It must choose one of the servers for which
+// info.Available returns true.
+//
+// A Selector is shared by all calls of a XClient, so it must be safe for concurrent use.
+type Selector interface {
+	Select(servers []string, info *SelectInfo) (string, error)
+}
+
+// Feedback is implemented by selectors interested in the progress of calls,
+// XClient reports every call sent to the selected server.
+type Feedback interface {
+	Start(server string)
+	Finish(server string, err error, latency time.Duration)
+}
+
+// NewSelectorFunc creates a new Selector, every XClient or Discovery owns its own selector.
+type NewSelectorFunc func() Selector
+
+// NewSelectorFuncMap maps select modes to selectors, register a NewSelectorFunc with
+// a new SelectMode to add a custom balancer used by NewXClient.
+var NewSelectorFuncMap map[SelectMode]NewSelectorFunc
+
+func init() {
+	NewSelectorFuncMap = make(map[SelectMode]NewSelectorFunc)
+	NewSelectorFuncMap[RandomSelect] = NewRandomSelector
+	NewSelectorFuncMap[RoundRobinSelect] = NewRoundRobinSelector
+	NewSelectorFuncMap[WeightedRoundRobinSelect] = NewWeightedRoundRobinSelector
+	NewSelectorFuncMap[WeightedRandomSelect] = NewWeightedRandomSelector
+	NewSelectorFuncMap[ConsistentHashSelect] = NewConsistentHashSelector
+	NewSelectorFuncMap[LeastActiveSelect] = NewLeastActiveSelector
+	NewSelectorFuncMap[P2CSelect] = NewP2CSelector
+}
+
+var errNoServers = errors.New("rpc discovery: no available servers")
+
+// defaultWeight is the weight of a server whose weight is never set.
+const defaultWeight = 1
+
+func weightOf(info *SelectInfo, server string) int {
+	if weight, ok := info.Weights[server]; ok {
+		return weight
+	}
+	return defaultWeight
+}
+
+type randomSelector struct {
+	mu sync.Mutex // protect r
+	r  *rand.Rand // generate random number
+}
+
+func NewRandomSelector() Selector {
+	return &randomSelector{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *randomSelector) Select(servers []string, info *SelectInfo) (string, error) {
+	servers = availableServers(servers, info)
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return servers[s.r.Intn(len(servers))], nil
+}
+
+type roundRobinSelector struct {
+	mu    sync.Mutex
+	index int // record the selected position for robin algorithm
+}
+
+func NewRoundRobinSelector() Selector {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &roundRobinSelector{index: r.Intn(math.MaxInt32 - 1)}
+}
+
+// Select picks the next available server, the position is kept over all servers,
+// so unavailable servers are skipped without shifting the sequence of the others.
+func (s *roundRobinSelector) Select(servers []string, info *SelectInfo) (string, error) {
+	n := len(servers)
+	if n == 0 {
+		return "", errNoServers
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		server := servers[(s.index+i)%n]
+		if info.available(server) {
+			s.index = (s.index + i + 1) % n
+			return server, nil
+		}
+	}
+	return "", errNoServers
+}
+
+type weightedRoundRobinSelector struct {
+	mu      sync.Mutex
+	current map[string]int // current weight of servers
+}
+
+func NewWeightedRoundRobinSelector() Selector {
+	return &weightedRoundRobinSelector{current: make(map[string]int)}
+}
+
+// Select picks a server using smooth weighted round-robin algorithm (as nginx does).
+//
+// Every pick, each server's current weight increases by its weight, the server with the
+// largest current weight is selected and its current weight decreases by the total weight.
+// Current weights survive weight changes and unavailable servers, so the sequence stays smooth.
+func (s *weightedRoundRobinSelector) Select(servers []string, info *SelectInfo) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best string
+	total, bestWeight := 0, 0
+	alive := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		alive[server] = true
+		weight := weightOf(info, server)
+		if weight == 0 || !info.available(server) {
+			continue
+		}
+		total += weight
+		s.current[server] += weight
+		if best == "" || s.current[server] > bestWeight {
+			best, bestWeight = server, s.current[server]
+		}
+	}
+	// forget servers which are gone
+	for server := range s.current {
+		if !alive[server] {
+			delete(s.current, server)
+		}
+	}
+	if best == "" {
+		return "", errors.New("rpc discovery: no available servers with positive weight")
+	}
+	s.current[best] -= total
+	return best, nil
+}
+
+type weightedRandomSelector struct {
+	mu sync.Mutex // protect r
+	r  *rand.Rand
+}
+
+func NewWeightedRandomSelector() Selector {
+	return &weightedRandomSelector{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Select picks a server randomly, the probability is proportional to its weight.
+func (s *weightedRandomSelector) Select(servers []string, info *SelectInfo) (string, error) {
+	servers = availableServers(servers, info)
+	total := 0
+	for _, server := range servers {
+		total += weightOf(info, server)
+	}
+	if total == 0 {
+		return "", errors.New("rpc discovery: no available servers with positive weight")
+	}
+	s.mu.Lock()
+	n := s.r.Intn(total)
+	s.mu.Unlock()
+	for _, server := range servers {
+		if n -= weightOf(info, server); n < 0 {
+			return server, nil
+		}
+	}
+	return "", errors.New("rpc discovery: no available servers with positive weight")
+}
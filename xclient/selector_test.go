@@ -0,0 +1,49 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// firstSelector always selects the first server, it's a custom balancer for tests.
+type firstSelector struct{}
+
+func (firstSelector) Select(servers []string, _ *SelectInfo) (string, error) {
+	return servers[0], nil
+}
+
+func TestXClient_Selector(t *testing.T) {
+	const firstSelect SelectMode = 100
+	NewSelectorFuncMap[firstSelect] = func() Selector { return firstSelector{} }
+	defer delete(NewSelectorFuncMap, firstSelect)
+
+	d := NewMultiServiceDiscovery([]string{"a", "b"})
+	xc := NewXClient(d, firstSelect, nil)
+	xc.SetBreaker(&BreakerOption{MaxFailures: 1, OpenTimeout: DefaultBreakerOption.OpenTimeout})
+
+	s, b, err := xc.selectServer(context.Background(), "Foo.Sum", nil)
+	_assert(err == nil && s == "a", "expect the registered selector to select a, got %s", s)
+
+	b.done(errors.New("failed"))
+	s, _, err = xc.selectServer(context.Background(), "Foo.Sum", nil)
+	_assert(err == nil && s == "b", "expect a skipped when its breaker is open, got %s", s)
+
+	_, _, err = NewXClient(d, SelectMode(-1), nil).selectServer(context.Background(), "Foo.Sum", nil)
+	_assert(err != nil, "expect an error for unknown select mode")
+}
+
+func TestWeightedRoundRobinSelector_Available(t *testing.T) {
+	s := NewWeightedRoundRobinSelector()
+	servers := []string{"a", "b", "c"}
+	info := &SelectInfo{Weights: map[string]int{"a": 2, "b": 1, "c": 1}}
+	down := &SelectInfo{Weights: info.Weights, Available: func(server string) bool { return server != "b" }}
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		server, _ := s.Select(servers, info)
+		counts[server]++
+		server, _ = s.Select(servers, down)
+		_assert(server != "b", "expect unavailable b skipped")
+	}
+	_assert(counts["b"] > 0, "expect b selected again when available, got %v", counts)
+}
@@ -2,48 +2,271 @@ package xclient
 
 import (
 	"context"
+	"errors"
 	"geerpc"
 	"io"
+	"log"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type XClient struct {
-	d       Discovery
-	mode    SelectMode
-	opt     *geerpc.Option
-	mu      sync.Mutex // protect following
-	clients map[string]*geerpc.Client
+	d        Discovery
+	mode     SelectMode
+	selector Selector // choose a server for every call, nil if mode is not supported
+	opt      *geerpc.Option
+	breakers *breakerGroup    // nil means circuit breaking is disabled
+	keyFunc  KeyFunc          // extract routing key from args for ConsistentHashSelect
+	health   *healthChecker   // nil means active health checking is disabled
+	outliers *outlierDetector // nil means outlier detection is disabled
+	router   *router          // nil means label-aware routing is disabled
+	// unsubscribe stops receiving changes of servers, nil if d isn't a SubscribableDiscovery.
+	unsubscribe func()
+	mu          sync.Mutex // protect following
+	clients     map[string]*geerpc.Client
+	users       map[*geerpc.Client]int      // calls using every client, see dial
+	draining    map[*geerpc.Client]struct{} // clients of removed servers, closed once unused
+	servers     map[string]bool             // servers listed by a SubscribableDiscovery, nil if unknown
+	prewarm     bool                        // dial servers as soon as they are added to discovery
+	closed      bool
 }
 
+// time a client of a removed server is kept for its calls in flight
+const drainTimeout = time.Second * 30
+
 var _ io.Closer = (*geerpc.Client)(nil)
 
+// NewXClient creates a XClient choosing servers by the selector registered for mode in NewSelectorFuncMap.
 func NewXClient(d Discovery, mode SelectMode, opt *geerpc.Option) *XClient {
-	return &XClient{
-		d:       d,
-		mode:    mode,
-		opt:     opt,
-		clients: make(map[string]*geerpc.Client),
+	var selector Selector
+	if f := NewSelectorFuncMap[mode]; f != nil {
+		selector = f()
+	}
+	xc := &XClient{
+		d:        d,
+		mode:     mode,
+		selector: selector,
+		opt:      opt,
+		clients:  make(map[string]*geerpc.Client),
+		users:    make(map[*geerpc.Client]int),
+		draining: make(map[*geerpc.Client]struct{}),
+	}
+	if sd, ok := d.(SubscribableDiscovery); ok {
+		xc.unsubscribe = sd.Subscribe(xc.onDiscoveryEvent)
+	}
+	return xc
+}
+
+// EnablePrewarm dials servers as soon as the discovery reports them, so the first calls
+// to new servers don't wait for connecting. It works with a SubscribableDiscovery only.
+// It should be called before xc is used.
+func (xc *XClient) EnablePrewarm() {
+	xc.mu.Lock()
+	xc.prewarm = true
+	xc.mu.Unlock()
+
+	// servers reported before prewarm was enabled
+	go func() {
+		servers, _ := xc.d.GetAll()
+		xc.warm(servers)
+	}()
+}
+
+// onDiscoveryEvent drains connections to servers removed from discovery and forgets
+// their breaker and load states, and dials added servers if prewarm is enabled.
+//
+// Removed servers aren't selected anymore, but calls in flight to them go on, their
+// connection is closed once they are done, or after drainTimeout.
+func (xc *XClient) onDiscoveryEvent(event DiscoveryEvent) {
+	for _, server := range event.Removed {
+		if xc.breakers != nil {
+			xc.breakers.remove(server)
+		}
+		if f, ok := xc.selector.(interface{ Forget(server string) }); ok {
+			f.Forget(server)
+		}
+	}
+
+	xc.mu.Lock()
+	if xc.servers == nil {
+		xc.servers = make(map[string]bool)
+	}
+	for _, server := range event.Added {
+		xc.servers[server] = true
+	}
+	for _, server := range event.Removed {
+		delete(xc.servers, server)
+		if client, ok := xc.clients[server]; ok {
+			delete(xc.clients, server)
+			xc.drain(client)
+		}
+	}
+	xc.mu.Unlock()
+	xc.warm(event.Added)
+}
+
+// drain closes client at once if no call uses it, otherwise when the last call is done,
+// see release, or after drainTimeout. xc.mu must be held.
+func (xc *XClient) drain(client *geerpc.Client) {
+	if xc.users[client] == 0 {
+		_ = client.Close()
+		return
+	}
+	xc.draining[client] = struct{}{}
+	time.AfterFunc(drainTimeout, func() {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		if _, ok := xc.draining[client]; ok {
+			delete(xc.draining, client)
+			_ = client.Close()
+		}
+	})
+}
+
+// warm dials servers in background if prewarm is enabled.
+func (xc *XClient) warm(servers []string) {
+	xc.mu.Lock()
+	prewarm := xc.prewarm && !xc.closed
+	xc.mu.Unlock()
+	if !prewarm {
+		return
+	}
+	for _, server := range servers {
+		go func(server string) {
+			_, release, err := xc.dial(server)
+			if err != nil {
+				log.Println("rpc xclient: prewarm", server, "err:", err)
+				return
+			}
+			release()
+		}(server)
+	}
+}
+
+// SetSelector replaces the selector chosen by mode with a custom one.
+// It should be called before xc is used.
+func (xc *XClient) SetSelector(s Selector) {
+	xc.selector = s
+}
+
+// SetBreaker enables circuit breaking with the option used for every server, e.g. DefaultBreakerOption,
+// existing breaker states are dropped. nil disables circuit breaking, which is the default.
+// Zero fields of opt are taken from DefaultBreakerOption, except MaxFailures and ErrorRate.
+// It should be called before xc is used.
+func (xc *XClient) SetBreaker(opt *BreakerOption) {
+	if opt == nil {
+		xc.breakers = nil
+		return
+	}
+	xc.breakers = newBreakerGroup(opt)
+}
+
+// Breakers returns the circuit breaker state of every server called so far.
+func (xc *XClient) Breakers() []BreakerStat {
+	if xc.breakers == nil {
+		return nil
+	}
+	return xc.breakers.stats()
+}
+
+// Loads returns the in-flight calls and latency EWMA of every server called so far,
+// nil if the selector doesn't track load.
+func (xc *XClient) Loads() []LoadStat {
+	if r, ok := xc.selector.(interface{ Loads() []LoadStat }); ok {
+		return r.Loads()
+	}
+	return nil
+}
+
+// SetKeyFunc sets the function extracting the routing key from args for ConsistentHashSelect,
+// it's used when the context of a call carries no routing key set by WithRoutingKey.
+func (xc *XClient) SetKeyFunc(f KeyFunc) {
+	xc.keyFunc = f
+}
+
+// EnableHealthCheck starts checking the health of every server of discovery in background,
+// unhealthy servers are skipped by selection until they recover. nil means DefaultHealthCheckOption.
+// It should be called once before xc is used, the checker stops when xc is closed.
+func (xc *XClient) EnableHealthCheck(opt *HealthCheckOption) {
+	if opt == nil {
+		opt = DefaultHealthCheckOption
+	}
+	xc.health = newHealthChecker(xc, opt)
+	go xc.health.run()
+}
+
+// Health returns the health of every server checked so far, nil if health checking is disabled.
+func (xc *XClient) Health() []HealthStat {
+	if xc.health == nil {
+		return nil
 	}
+	return xc.health.stats()
+}
+
+// EnableOutlierDetection starts ejecting servers whose error rate or latency is statistically
+// worse than the rest of servers, nil means DefaultOutlierOption.
+// It should be called once before xc is used, the detector stops when xc is closed.
+func (xc *XClient) EnableOutlierDetection(opt *OutlierOption) {
+	if opt == nil {
+		opt = DefaultOutlierOption
+	}
+	xc.outliers = newOutlierDetector(xc, opt)
+	go xc.outliers.run()
+}
+
+// Ejections returns the outlier detection state of every server called recently,
+// nil if outlier detection is disabled.
+func (xc *XClient) Ejections() []EjectionStat {
+	if xc.outliers == nil {
+		return nil
+	}
+	return xc.outliers.stats()
+}
+
+// SetRoute routes calls to servers by their labels, see RouteOption. nil disables routing.
+// It should be called before xc is used.
+func (xc *XClient) SetRoute(opt *RouteOption) {
+	if opt == nil {
+		xc.router = nil
+		return
+	}
+	xc.router = newRouter(opt)
 }
 
 func (xc *XClient) Close() error {
+	if xc.unsubscribe != nil {
+		xc.unsubscribe()
+	}
+	if xc.health != nil {
+		xc.health.stop()
+	}
+	if xc.outliers != nil {
+		xc.outliers.stop()
+	}
+
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 
+	xc.closed = true
 	for key, client := range xc.clients {
 		// ignore err
 		_ = client.Close()
 		delete(xc.clients, key)
 	}
+	for client := range xc.draining {
+		_ = client.Close()
+		delete(xc.draining, client)
+	}
 
 	return nil
 }
 
-func (xc *XClient) dial(rpcAddr string) (*geerpc.Client, error) {
+// dial returns the cached client of rpcAddr, or dials rpcAddr, release must be called once
+// the client isn't used anymore. A server removed from discovery while dialing isn't cached,
+// its client is closed by release.
+func (xc *XClient) dial(rpcAddr string) (client *geerpc.Client, release func(), err error) {
 	xc.mu.Lock()
-	defer xc.mu.Unlock()
-
 	// Check wether xc.clients has cached clients
 	client, ok := xc.clients[rpcAddr]
 	if ok && !client.IsAvailable() {
@@ -51,37 +274,208 @@ func (xc *XClient) dial(rpcAddr string) (*geerpc.Client, error) {
 		delete(xc.clients, rpcAddr)
 		client = nil
 	}
-	if client == nil {
-		var err error
-		client, err = geerpc.XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
-		}
+	if client != nil {
+		xc.users[client]++
+		xc.mu.Unlock()
+		return client, func() { xc.release(client) }, nil
+	}
+	xc.mu.Unlock()
+
+	// dial without xc.mu, so an unreachable server doesn't block calls to other servers.
+	client, err = geerpc.XDial(rpcAddr, xc.opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.closed {
+		_ = client.Close()
+		return nil, nil, geerpc.ErrShutdown
+	}
+	if cached, ok := xc.clients[rpcAddr]; ok && cached.IsAvailable() {
+		// dialed by another call meanwhile
+		_ = client.Close()
+		client = cached
+	} else if xc.servers == nil || xc.servers[rpcAddr] {
 		xc.clients[rpcAddr] = client
+	} else {
+		// removed from discovery meanwhile, close client once this call is done
+		xc.draining[client] = struct{}{}
 	}
+	xc.users[client]++
+	return client, func() { xc.release(client) }, nil
+}
+
+// release marks a call using client done, client is closed if it's draining and unused.
+func (xc *XClient) release(client *geerpc.Client) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
 
-	return client, nil
+	if xc.users[client]--; xc.users[client] > 0 {
+		return
+	}
+	delete(xc.users, client)
+	if _, ok := xc.draining[client]; ok {
+		delete(xc.draining, client)
+		_ = client.Close()
+	}
 }
 
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
+	fb, ok := xc.selector.(Feedback)
+	if !ok {
+		client, release, err := xc.dial(rpcAddr)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return client.Call(ctx, serviceMethod, args, reply)
+	}
+
+	// dial failures are reported too, the selector should avoid servers refusing connections.
+	fb.Start(rpcAddr)
+	start := time.Now()
+	client, release, err := xc.dial(rpcAddr)
+	if err == nil {
+		err = client.Call(ctx, serviceMethod, args, reply)
+		release()
+	}
+	fbErr := err
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		fbErr = context.Canceled
+	}
+	fb.Finish(rpcAddr, fbErr, time.Since(start))
+	return err
+}
+
+// record reports the result of a call to the circuit breaker b and outlier detector.
+func (xc *XClient) record(rpcAddr string, b *breaker, ctx context.Context, err error, latency time.Duration) {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		// canceled by caller, it says nothing about the server's health.
+		err = context.Canceled
+	}
+	if b != nil {
+		b.done(err)
+	}
+	if xc.outliers != nil && err != context.Canceled {
+		xc.outliers.record(rpcAddr, err, latency)
+	}
+}
+
+// routingKey returns the routing key of a call, from ctx first and then from xc.keyFunc.
+func (xc *XClient) routingKey(ctx context.Context, serviceMethod string, args interface{}) string {
+	if key, ok := RoutingKeyFrom(ctx); ok {
+		return key
+	}
+	if xc.keyFunc != nil {
+		return xc.keyFunc(serviceMethod, args)
+	}
+	return ""
+}
+
+// available reports whether rpcAddr is healthy, not ejected and its circuit breaker isn't open.
+func (xc *XClient) available(rpcAddr string) bool {
+	if xc.health != nil && !xc.health.healthy(rpcAddr) {
+		return false
+	}
+	if xc.outliers != nil && xc.outliers.ejected(rpcAddr) {
+		return false
+	}
+	return xc.breakers == nil || xc.breakers.get(rpcAddr).ready()
+}
+
+// selectServer lets the selector choose a server from all servers of discovery,
+// unhealthy servers, ejected outliers and servers whose circuit breaker is open are skipped,
+// then the rest is filtered by labels if routing is enabled.
+//
+// The selector is given all servers and skips the others by SelectInfo.Available,
+// so its state, e.g. a hash ring, isn't rebuilt whenever a server becomes unavailable.
+func (xc *XClient) selectServer(ctx context.Context, serviceMethod string, args interface{}) (string, *breaker, error) {
+	if xc.selector == nil {
+		return "", nil, errors.New("rpc discovery: not supported select mode")
+	}
+	servers, err := xc.d.GetAll()
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	if len(servers) == 0 {
+		return "", nil, errNoServers
+	}
+
+	candidates := make([]string, 0, len(servers))
+	for _, rpcAddr := range servers {
+		if xc.available(rpcAddr) {
+			candidates = append(candidates, rpcAddr)
+		}
+	}
+	if xc.router != nil {
+		var labels map[string]map[string]string
+		if ld, ok := xc.d.(LabeledDiscovery); ok {
+			labels = ld.Labels()
+		}
+		candidates = xc.router.route(candidates, labels)
+	}
+	allowed := make(map[string]bool, len(candidates))
+	for _, rpcAddr := range candidates {
+		allowed[rpcAddr] = true
+	}
+
+	info := &SelectInfo{
+		Ctx:           ctx,
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Key:           xc.routingKey(ctx, serviceMethod, args),
+		Available:     func(server string) bool { return allowed[server] },
+	}
+	if wd, ok := xc.d.(WeightedDiscovery); ok {
+		info.Weights = wd.Weights()
+	}
+	for len(candidates) > 0 {
+		rpcAddr, err := xc.selector.Select(servers, info)
+		if err == nil && !allowed[rpcAddr] {
+			// the selector ignores info.Available, let it choose from candidates only.
+			rpcAddr, err = xc.selector.Select(candidates, info)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if xc.breakers == nil {
+			return rpcAddr, nil, nil
+		}
+		if b := xc.breakers.get(rpcAddr); b.allow() {
+			return rpcAddr, b, nil
+		}
+		// the breaker was tripped or its probes were taken meanwhile, try others.
+		delete(allowed, rpcAddr)
+		candidates = remove(candidates, rpcAddr)
 	}
+	return "", nil, ErrNoAvailableServer
+}
 
-	return client.Call(ctx, serviceMethod, args, reply)
+// remove returns servers without server, servers is modified.
+func remove(servers []string, server string) []string {
+	for i := range servers {
+		if servers[i] == server {
+			return append(servers[:i], servers[i+1:]...)
+		}
+	}
+	return servers
 }
 
 // Call invokes the named function, waits for it to complete and returns its error status.
 //
 // xc will choose a proper server.
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	rpcAddr, b, err := xc.selectServer(ctx, serviceMethod, args)
 	if err != nil {
 		return err
 	}
 
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	start := time.Now()
+	err = xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	xc.record(rpcAddr, b, ctx, err, time.Since(start))
+	return err
 }
 
 // Broadcast invokes the named function for every server registered in discovery.